@@ -8,15 +8,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/codepnw/social/docs" // This is required to generate swagger docs
 	"github.com/codepnw/social/internal/auth"
 	"github.com/codepnw/social/internal/mailer"
+	"github.com/codepnw/social/internal/oauth"
 	"github.com/codepnw/social/internal/ratelimiter"
 	"github.com/codepnw/social/internal/store"
 	"github.com/codepnw/social/internal/store/cache"
+	"github.com/codepnw/social/internal/unfurl"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -31,7 +34,10 @@ type application struct {
 	logger        *zap.SugaredLogger
 	mailer        mailer.Client
 	authenticator auth.Authenticator
-	rateLimiter   ratelimiter.Limiter
+	rateLimiters  map[string]ratelimiter.Limiter
+	unfurler      *unfurl.Unfurler
+	// oauthProviders are the social login providers by name
+	oauthProviders map[string]*oauth.Provider
 }
 
 type config struct {
@@ -43,7 +49,18 @@ type config struct {
 	frontendURL string
 	auth        authConfig
 	redisCfg    redisCfg
+	cache       cache.Config
 	rateLimiter ratelimiter.Config
+	idempotency idempotencyConfig
+	scheduler   schedulerConfig
+	linkPreview linkPreviewConfig
+	oauth       oauthConfig
+}
+
+type idempotencyConfig struct {
+	ttl time.Duration
+	// maxBodyBytes is the largest response body stored for replay
+	maxBodyBytes int
 }
 
 type redisCfg struct {
@@ -56,12 +73,15 @@ type redisCfg struct {
 type authConfig struct {
 	basic basicConfig
 	token tokenConfig
+	login loginConfig
 }
 
 type tokenConfig struct {
 	secret string
 	exp    time.Duration
 	iss    string
+	// mfaExp is how long a login has to enter its two-factor code
+	mfaExp time.Duration
 }
 
 type basicConfig struct {
@@ -89,20 +109,16 @@ type dbConfig struct {
 func (app *application) mount() http.Handler {
 	r := chi.NewRouter()
 
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}))
+	r.Use(app.corsMiddleware)
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(app.RateLimiterMiddleware)
+
+	// before authentication, so bad tokens cost a client its budget too,
+	// the per-user policies of the routes come after
+	r.Use(app.rateLimitMiddleware(rateLimitIP))
 
 	// Set a timeout value on the request context (ctx), that will signal
 	// through ctx.Done() that the request has timed out and further
@@ -111,53 +127,248 @@ func (app *application) mount() http.Handler {
 
 	r.Route("/v1", func(r chi.Router) {
 		// Operations
-		r.Get("/health", app.healthCheckHandler)
-		r.With(app.BasicAuthMiddleware()).Get("/debug/vars", expvar.Handler().ServeHTTP)
+		r.Group(func(r chi.Router) {
+			r.Use(app.rateLimitMiddleware(rateLimitDefault))
 
-		docsURL := fmt.Sprintf("%s/swagger/doc.json", app.config.addr)
-		r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
+			r.Get("/health", app.healthCheckHandler)
+			r.With(app.BasicAuthMiddleware()).Get("/debug/vars", expvar.Handler().ServeHTTP)
+
+			docsURL := fmt.Sprintf("%s/swagger/doc.json", app.config.addr)
+			r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
+		})
 
 		r.Route("/posts", func(r chi.Router) {
+			r.Use(app.tokenScopeMiddleware("posts"))
 			r.Use(app.AuthTokenMiddleware)
-			r.Post("/", app.createPostHandler)
+			r.Use(app.readWriteRateLimitMiddleware)
+			r.With(app.idempotencyMiddleware).Post("/", app.createPostHandler)
 
 			r.Route("/{postID}", func(r chi.Router) {
 				r.Use(app.postsContextMiddleware)
 
 				r.Get("/", app.getPostHandler)
-				r.Patch("/", app.checkPostOwnership("moderator", app.updatePostHandler))
-				r.Delete("/", app.checkPostOwnership("admin", app.deletePostHandler))
+				r.Patch("/", app.checkPostOwnership(store.PermissionPostUpdateAny, app.updatePostHandler))
+				r.Delete("/", app.checkPostOwnership(store.PermissionPostDeleteAny, app.deletePostHandler))
+				r.Put("/repost", app.repostPostHandler)
+				r.Put("/unrepost", app.unrepostPostHandler)
+				r.Post("/poll/vote", app.votePollHandler)
+				// edit history can hold what the author took out, keep it to
+				// the author and those who may edit the post
+				r.Get("/revisions", app.checkPostOwnership(store.PermissionPostUpdateAny, app.getPostRevisionsHandler))
+				r.Get("/revisions/diff", app.checkPostOwnership(store.PermissionPostUpdateAny, app.getPostRevisionDiffHandler))
+				r.Delete("/comments/{commentID}", app.deleteCommentHandler)
+			})
+		})
+
+		r.Route("/tags", func(r chi.Router) {
+			r.Use(app.tokenScopeMiddleware("tags"))
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.readWriteRateLimitMiddleware)
+			r.Get("/trending", app.getTrendingTagsHandler)
+			r.Get("/following", app.getFollowedTagsHandler)
+
+			r.Route("/{tag}", func(r chi.Router) {
+				r.Get("/posts", app.getTagPostsHandler)
+				r.Put("/follow", app.followTagHandler)
+				r.Put("/unfollow", app.unfollowTagHandler)
 			})
 		})
 
 		r.Route("/users", func(r chi.Router) {
-			r.Put("/activate/{token}", app.activateUserHandler)
+			r.With(app.rateLimitMiddleware(rateLimitDefault)).Put("/activate/{token}", app.activateUserHandler)
 
 			r.Route("/{userID}", func(r chi.Router) {
+				r.Use(app.tokenScopeMiddleware("users"))
 				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.readWriteRateLimitMiddleware)
 
 				r.Get("/", app.getUserHandler)
 				r.Put("/follow", app.followUserHandler)
 				r.Put("/unfollow", app.unfollowUserHandler)
+				r.Put("/block", app.blockUserHandler)
+				r.Put("/unblock", app.unblockUserHandler)
+				r.Put("/mute", app.muteUserHandler)
+				r.Put("/unmute", app.unmuteUserHandler)
 			})
 
 			r.Group(func(r chi.Router) {
+				r.Use(app.tokenScopeMiddleware("users"))
 				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.readWriteRateLimitMiddleware)
 
 				r.Get("/feed", app.getUserFeedHandler)
+				r.Patch("/me/settings", app.updateUserSettingsHandler)
+				r.Get("/me/drafts", app.getDraftsHandler)
+				r.Get("/me/login-events", app.getLoginEventsHandler)
+
+				r.Route("/me/follow-requests", func(r chi.Router) {
+					r.Get("/", app.getFollowRequestsHandler)
+					r.Put("/{requesterID}/approve", app.approveFollowRequestHandler)
+					r.Put("/{requesterID}/reject", app.rejectFollowRequestHandler)
+				})
+			})
+
+			r.Route("/me/2fa", func(r chi.Router) {
+				r.Use(app.TwoFactorSetupAuthMiddleware)
+				r.Use(app.readWriteRateLimitMiddleware)
+
+				r.Post("/enroll", app.enrollTwoFactorHandler)
+				r.Post("/enable", app.enableTwoFactorHandler)
+				r.Post("/disable", app.disableTwoFactorHandler)
+				r.Post("/recovery-codes", app.regenerateRecoveryCodesHandler)
+			})
+
+			// only a login can manage tokens, never a personal access token
+			r.Route("/me/tokens", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.readWriteRateLimitMiddleware)
+
+				r.Get("/", app.listPersonalTokensHandler)
+				r.Post("/", app.createPersonalTokenHandler)
+				r.Delete("/{tokenID}", app.revokePersonalTokenHandler)
+			})
+
+			r.Route("/me/sessions", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.readWriteRateLimitMiddleware)
+
+				r.Get("/", app.listSessionsHandler)
+				r.Put("/revoke-others", app.revokeOtherSessionsHandler)
+				r.Delete("/{sessionID}", app.revokeSessionHandler)
 			})
 		})
 
+		r.Route("/conversations", func(r chi.Router) {
+			r.Use(app.tokenScopeMiddleware("messages"))
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.readWriteRateLimitMiddleware)
+			r.Get("/", app.listConversationsHandler)
+			r.Post("/", app.createConversationHandler)
+
+			r.Route("/{conversationID}", func(r chi.Router) {
+				r.Use(app.conversationsContextMiddleware)
+
+				r.Get("/messages", app.listMessagesHandler)
+				r.With(app.idempotencyMiddleware).Post("/messages", app.createMessageHandler)
+				r.Put("/read", app.readConversationHandler)
+			})
+		})
+
+		r.Route("/reports", func(r chi.Router) {
+			r.Use(app.tokenScopeMiddleware("reports"))
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.readWriteRateLimitMiddleware)
+			r.With(app.idempotencyMiddleware).Post("/", app.createReportHandler)
+		})
+
+		r.Route("/moderation", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.readWriteRateLimitMiddleware)
+			r.Use(app.requirePermission(store.PermissionReportModerate))
+
+			r.Route("/reports", func(r chi.Router) {
+				r.Get("/", app.listReportsHandler)
+
+				r.Route("/{reportID}", func(r chi.Router) {
+					r.Use(app.reportsContextMiddleware)
+
+					r.Get("/", app.getReportHandler)
+					r.Put("/claim", app.claimReportHandler)
+					r.Put("/resolve", app.resolveReportHandler)
+					r.Put("/dismiss", app.dismissReportHandler)
+				})
+			})
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.readWriteRateLimitMiddleware)
+
+			r.Route("/users", func(r chi.Router) {
+				r.With(app.requirePermission(store.PermissionUserList)).Get("/", app.adminListUsersHandler)
+
+				r.Route("/{userID}", func(r chi.Router) {
+					r.With(app.requirePermission(store.PermissionUserRoleAssign)).Put("/role", app.adminUpdateUserRoleHandler)
+					r.With(app.requirePermission(store.PermissionUserSuspend)).Put("/suspend", app.adminSuspendUserHandler)
+					r.With(app.requirePermission(store.PermissionUserSuspend)).Put("/unsuspend", app.adminUnsuspendUserHandler)
+					r.With(app.requirePermission(store.PermissionUserActivate)).Put("/activate", app.adminActivateUserHandler)
+					r.With(app.requirePermission(store.PermissionUserLogout)).Put("/logout", app.adminLogoutUserHandler)
+				})
+			})
+
+			r.Route("/roles", func(r chi.Router) {
+				r.Use(app.requirePermission(store.PermissionRoleManage))
+
+				r.Get("/", app.adminListRolesHandler)
+				r.Post("/", app.adminCreateRoleHandler)
+
+				r.Route("/{roleID}", func(r chi.Router) {
+					r.Use(app.rolesContextMiddleware)
+
+					r.Get("/", app.adminGetRoleHandler)
+					r.Patch("/", app.adminUpdateRoleHandler)
+					r.Delete("/", app.adminDeleteRoleHandler)
+
+					r.Get("/permissions", app.adminGetRolePermissionsHandler)
+					r.Put("/permissions/{permission}", app.adminGrantPermissionHandler)
+					r.Delete("/permissions/{permission}", app.adminRevokePermissionHandler)
+				})
+			})
+
+			r.With(app.requirePermission(store.PermissionRoleManage)).Get("/permissions", app.adminListPermissionsHandler)
+			r.With(app.requirePermission(store.PermissionAuditRead)).Get("/audit-events", app.adminListAuditEventsHandler)
+		})
+
 		// Public routes
 		r.Route("/auth", func(r chi.Router) {
-			r.Post("/user", app.registerUserHandler)
-			r.Post("/token", app.createTokenHandler)
+			r.With(app.rateLimitMiddleware(rateLimitRegister), app.idempotencyMiddleware).Post("/user", app.registerUserHandler)
+			r.With(app.rateLimitMiddleware(rateLimitLogin)).Post("/token", app.createTokenHandler)
+			r.With(app.rateLimitMiddleware(rateLimitLogin)).Post("/token/2fa", app.verifyTwoFactorHandler)
+			r.With(app.rateLimitMiddleware(rateLimitDefault)).Put("/unlock/{token}", app.unlockAccountHandler)
+
+			r.Route("/oauth/{provider}", func(r chi.Router) {
+				r.Use(app.rateLimitMiddleware(rateLimitLogin))
+				r.Get("/", app.startOAuthHandler)
+				r.Post("/callback", app.oauthCallbackHandler)
+			})
 		})
 	})
 
 	return r
 }
 
+// corsMiddleware lets any origin call the API, authenticating with bearer
+// tokens. Social login also needs the state cookie, so its routes only
+// take credentialed requests from the frontend.
+func (app *application) corsMiddleware(next http.Handler) http.Handler {
+	api := cors.Handler(cors.Options{
+		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match", "If-None-Match", "Idempotency-Key"},
+		ExposedHeaders:   []string{"Link", "ETag", "Idempotent-Replayed", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		AllowCredentials: false,
+		MaxAge:           300, // Maximum value not ignored by any of major browsers
+	})(next)
+
+	social := cors.Handler(cors.Options{
+		AllowedOrigins:   []string{app.config.frontendURL},
+		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Content-Type"},
+		ExposedHeaders:   []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		AllowCredentials: true,
+		MaxAge:           300,
+	})(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, oauthCookiePath) {
+			social.ServeHTTP(w, r)
+			return
+		}
+
+		api.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) run(mux http.Handler) error {
 	// Docs
 	docs.SwaggerInfo.Version = version
@@ -172,6 +383,15 @@ func (app *application) run(mux http.Handler) error {
 		IdleTimeout:  time.Minute,
 	}
 
+	// background jobs stop when run returns
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	go app.runPostScheduler(jobsCtx)
+	go app.runTrendingRefresher(jobsCtx)
+	go app.runPurger(jobsCtx)
+	go app.cacheStorage.Listen(jobsCtx)
+
 	shutdown := make(chan error)
 
 	go func() {
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// AdminListAuditEvents godoc
+//
+//	@Summary		Queries the audit log
+//	@Description	Lists audit events by actor, target and time range, newest first
+//	@Tags			admin
+//	@Produce		json
+//	@Param			actor_id	query		int		false	"Actor user ID"
+//	@Param			target_type	query		string	false	"Target type (post, comment, user, role, report)"
+//	@Param			target_id	query		int		false	"Target ID"
+//	@Param			since		query		string	false	"From time, RFC 3339"
+//	@Param			until		query		string	false	"Until time, RFC 3339"
+//	@Param			limit		query		int		false	"Limit"
+//	@Param			offset		query		int		false	"Offset"
+//	@Success		200			{object}	[]store.AuditEvent
+//	@Failure		400			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/audit-events [get]
+func (app *application) adminListAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	aq := store.AuditQuery{
+		Limit:  50,
+		Offset: 0,
+	}
+
+	aq, err := aq.Parse(r)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(aq); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	events, err := app.store.Audit.List(r.Context(), aq)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, events); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// auditContext attaches an audit event for the authenticated user's action
+// to the request context, so the store writes it with the change.
+func (app *application) auditContext(r *http.Request, action, targetType string, targetID int64, before, after map[string]any) context.Context {
+	user := getUserFromContext(r)
+
+	event := &store.AuditEvent{
+		ActorID:    user.ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+		RequestID:  middleware.GetReqID(r.Context()),
+		IPAddress:  clientIP(r),
+	}
+
+	return store.WithAuditEvent(r.Context(), event)
+}
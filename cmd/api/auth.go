@@ -131,9 +131,11 @@ type CreateUserTokenPayload struct {
 //	@Accept			json
 //	@Produce		json
 //	@Param			payload	body		CreateUserTokenPayload	true	"User credentials"
-//	@Success		200		{string}	string					"Token"
+//	@Success		201		{string}	string					"Token"
+//	@Success		202		{object}	TwoFactorChallenge		"Two-factor authentication required"
 //	@Failure		400		{object}	error
 //	@Failure		401		{object}	error
+//	@Failure		429		{object}	error
 //	@Failure		500		{object}	error
 //	@Router			/auth/token [post]
 func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -148,26 +150,95 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// featch the user from the payload
-	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
+	ctx := r.Context()
+	lt := newLoginThrottle(payload.Email, r)
+
+	wait, err := app.loginWait(ctx, lt)
 	if err != nil {
-		switch err {
-		case store.ErrNotFound:
-			app.errorUnauthorized(w, r, err)
-		default:
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.errorLoginThrottled(w, r, wait)
+		return
+	}
+
+	// featch the user from the payload
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	if err != nil && err != store.ErrNotFound {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	// an unknown email goes through the same password check and failure
+	// count, so the response does not tell whether it is registered
+	if user == nil {
+		store.CompareDummyPassword(payload.Password)
+	} else {
+		err = user.Password.Compare(payload.Password)
+	}
+
+	if user == nil || err != nil {
+		if err := app.loginFailed(ctx, r, lt, user); err != nil {
 			app.errorInternalServer(w, r, err)
+			return
 		}
+
+		app.errorUnauthorized(w, r, errInvalidCredentials)
 		return
 	}
 
-	if err := user.Password.Compare(payload.Password); err != nil {
-		app.errorUnauthorized(w, r, err)
+	// the password was right, whatever the second factor turns out to be
+	app.resetLoginFailures(ctx, lt.emailKey)
+
+	app.completeLogin(w, r, user)
+}
+
+// completeLogin signs in a user who proved who they are, asking for the
+// second factor first when they have one.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *store.User) {
+	ctx := r.Context()
+
+	tf, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil && err != store.ErrNotFound {
+		app.errorInternalServer(w, r, err)
 		return
 	}
 
+	if tf != nil && tf.Enabled {
+		app.twoFactorChallenge(w, r, user)
+		return
+	}
+
+	app.recordLogin(ctx, r, user)
+
+	token, err := app.startSession(ctx, r, user, false)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, token); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+const (
+	tokenTypeAccess = "access"
+	// tokenTypeMFA is only good for finishing a two-factor login
+	tokenTypeMFA = "mfa"
+)
+
+// generateAccessToken signs a token for the user in the session. mfa
+// records whether the login passed two-factor authentication.
+func (app *application) generateAccessToken(userID int64, sessionID string, mfa bool) (string, error) {
 	// generate the token -> add claims
 	claims := jwt.MapClaims{
-		"sub": user.ID,
+		"sub": userID,
+		"sid": sessionID,
+		"typ": tokenTypeAccess,
+		"mfa": mfa,
 		"exp": time.Now().Add(app.config.auth.token.exp).Unix(),
 		"iat": time.Now().Unix(),
 		"nbf": time.Now().Unix(),
@@ -175,13 +246,19 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		"aud": app.config.auth.token.iss,
 	}
 
-	token, err := app.authenticator.GenerateToken(claims)
-	if err != nil {
-		app.errorInternalServer(w, r, err)
-		return
-	}
+	return app.authenticator.GenerateToken(claims)
+}
 
-	if err := app.jsonResponse(w, http.StatusCreated, token); err != nil {
-		app.errorInternalServer(w, r, err)
+func (app *application) generateMFAToken(userID int64) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"typ": tokenTypeMFA,
+		"exp": time.Now().Add(app.config.auth.token.mfaExp).Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.auth.token.iss,
+		"aud": app.config.auth.token.iss,
 	}
+
+	return app.authenticator.GenerateToken(claims)
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+
+	"github.com/codepnw/social/internal/store"
+)
+
+// The getters read through the cache when it is enabled. The invalidate
+// helpers drop what a change made stale, so it shows on the next request
+// instead of when the TTL runs out; they only log errors, the change itself
+// already succeeded.
+
+func (app *application) getUser(ctx context.Context, userID int64) (*store.User, error) {
+	if !app.config.cache.Enabled {
+		return app.store.Users.GetByID(ctx, userID)
+	}
+
+	user, err := app.cacheStorage.Users.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the role is cached on its own, so a role update reaches all its users
+	role, err := app.getRole(ctx, user.RoleID)
+	if err != nil {
+		return nil, err
+	}
+	user.Role = *role
+
+	return user, nil
+}
+
+// invalidateUser drops the cached copy of a user after it changes, so the
+// next request sees the new role or status.
+func (app *application) invalidateUser(ctx context.Context, userID int64) {
+	if !app.config.cache.Enabled {
+		return
+	}
+
+	if err := app.cacheStorage.Users.Delete(ctx, userID); err != nil {
+		app.logger.Errorw("error invalidating cached user", "user_id", userID, "error", err)
+	}
+}
+
+func (app *application) getRole(ctx context.Context, roleID int64) (*store.Role, error) {
+	if !app.config.cache.Enabled {
+		return app.store.Roles.GetByID(ctx, roleID)
+	}
+
+	return app.cacheStorage.Roles.Get(ctx, roleID)
+}
+
+func (app *application) invalidateRole(ctx context.Context, roleID int64) {
+	if !app.config.cache.Enabled {
+		return
+	}
+
+	if err := app.cacheStorage.Roles.Delete(ctx, roleID); err != nil {
+		app.logger.Errorw("error invalidating cached role", "role_id", roleID, "error", err)
+	}
+}
+
+func (app *application) getRolePermissions(ctx context.Context, roleID int64) ([]string, error) {
+	if !app.config.cache.Enabled {
+		return app.store.Permissions.GetByRoleID(ctx, roleID)
+	}
+
+	return app.cacheStorage.Permissions.Get(ctx, roleID)
+}
+
+// invalidateRolePermissions drops the cached permissions of a role after
+// a grant or revoke.
+func (app *application) invalidateRolePermissions(ctx context.Context, roleID int64) {
+	if !app.config.cache.Enabled {
+		return
+	}
+
+	if err := app.cacheStorage.Permissions.Delete(ctx, roleID); err != nil {
+		app.logger.Errorw("error invalidating cached permissions", "role_id", roleID, "error", err)
+	}
+}
+
+// getPost returns the post whoever views it, see getVisiblePost.
+func (app *application) getPost(ctx context.Context, postID int64) (*store.Post, error) {
+	if !app.config.cache.Enabled {
+		return app.store.Posts.GetByID(ctx, postID)
+	}
+
+	return app.cacheStorage.Posts.Get(ctx, postID)
+}
+
+func (app *application) invalidatePosts(ctx context.Context, postIDs ...int64) {
+	if !app.config.cache.Enabled {
+		return
+	}
+
+	if err := app.cacheStorage.Posts.Delete(ctx, postIDs...); err != nil {
+		app.logger.Errorw("error invalidating cached posts", "post_ids", postIDs, "error", err)
+	}
+}
+
+func (app *application) getComments(ctx context.Context, postID, viewerID int64) ([]store.Comment, error) {
+	if !app.config.cache.Enabled {
+		return app.store.Comments.GetByPostID(ctx, postID, viewerID)
+	}
+
+	return app.cacheStorage.Comments.GetByPostID(ctx, postID, viewerID)
+}
+
+// invalidateComments drops the cached comment lists of a post, for every
+// viewer.
+func (app *application) invalidateComments(ctx context.Context, postID int64) {
+	if !app.config.cache.Enabled {
+		return
+	}
+
+	if err := app.cacheStorage.Comments.Delete(ctx, postID); err != nil {
+		app.logger.Errorw("error invalidating cached comments", "post_id", postID, "error", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// DeleteComment godoc
+//
+//	@Summary		Deletes a comment
+//	@Description	Deletes a comment on a post, either your own or any with comment:delete:any
+//	@Tags			posts
+//	@Produce		json
+//	@Param			postID		path		int		true	"Post ID"
+//	@Param			commentID	path		int		true	"Comment ID"
+//	@Success		204			{string}	string	"Comment deleted"
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/comments/{commentID} [delete]
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	commentID, err := strconv.ParseInt(chi.URLParam(r, "commentID"), 10, 64)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	post := getPostFromCtx(r)
+	ctx := r.Context()
+
+	comment, err := app.store.Comments.GetByID(ctx, post.ID, commentID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	if comment.UserID != user.ID {
+		allowed, err := app.hasPermission(ctx, user, store.PermissionCommentDeleteAny)
+		if err != nil {
+			app.errorInternalServer(w, r, err)
+			return
+		}
+
+		if !allowed {
+			app.errorForbidden(w, r)
+			return
+		}
+
+		// deleting someone else's comment is audited
+		ctx = app.auditContext(r, store.AuditCommentDelete, "comment", comment.ID, commentAuditState(comment), nil)
+	}
+
+	if err := app.store.Comments.Delete(ctx, comment.ID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateComments(ctx, post.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func commentAuditState(comment *store.Comment) map[string]any {
+	return map[string]any{
+		"user_id": comment.UserID,
+		"post_id": comment.PostID,
+		"content": comment.Content,
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type conversationKey string
+
+const conversationKeyCtx conversationKey = "conversation"
+
+type CreateConversationPayload struct {
+	UserID int64 `json:"user_id" validate:"required,gt=0"`
+}
+
+// CreateConversation godoc
+//
+//	@Summary		Starts a conversation
+//	@Description	Starts a direct conversation with a user, or returns the existing one
+//	@Tags			conversations
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateConversationPayload	true	"Conversation payload"
+//	@Success		200		{object}	store.Conversation	"Existing conversation"
+//	@Success		201		{object}	store.Conversation
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/conversations [post]
+func (app *application) createConversationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateConversationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if payload.UserID == user.ID {
+		app.errorBadRequest(w, r, errors.New("cannot start a conversation with yourself"))
+		return
+	}
+
+	ctx := r.Context()
+
+	recipient, err := app.store.Users.GetByID(ctx, payload.UserID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	allowed, err := app.canStartConversation(ctx, user, recipient)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if !allowed {
+		app.errorForbidden(w, r)
+		return
+	}
+
+	conversation, created, err := app.store.Conversations.GetOrCreate(ctx, user.ID, recipient.ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	if err := app.jsonResponse(w, status, conversation); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// ListConversations godoc
+//
+//	@Summary		Lists conversations
+//	@Description	Lists the user's conversations ordered by last activity
+//	@Tags			conversations
+//	@Accept			json
+//	@Produce		json
+//	@Param			limit	query		int	false	"Limit"
+//	@Param			offset	query		int	false	"Offset"
+//	@Success		200		{object}	[]store.Conversation
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/conversations [get]
+func (app *application) listConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	fq := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "desc",
+	}
+
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	conversations, err := app.store.Conversations.GetByUserID(r.Context(), user.ID, fq)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, conversations); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// ListMessages godoc
+//
+//	@Summary		Lists messages
+//	@Description	Pages backwards through a conversation, newest first
+//	@Tags			conversations
+//	@Accept			json
+//	@Produce		json
+//	@Param			conversationID	path		int	true	"Conversation ID"
+//	@Param			before			query		int	false	"Only messages with an ID lower than this"
+//	@Param			limit			query		int	false	"Limit"
+//	@Success		200				{object}	[]store.Message
+//	@Failure		400				{object}	error
+//	@Failure		404				{object}	error
+//	@Failure		500				{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationID}/messages [get]
+func (app *application) listMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	cq := store.CursorQuery{
+		Limit: 20,
+	}
+
+	cq, err := cq.Parse(r)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(cq); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	conversation := getConversationFromCtx(r)
+
+	messages, err := app.store.Messages.GetByConversationID(r.Context(), conversation.ID, cq)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, messages); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+type CreateMessagePayload struct {
+	Content string `json:"content" validate:"required,max=1000"`
+}
+
+// CreateMessage godoc
+//
+//	@Summary		Sends a message
+//	@Description	Sends a message in a conversation
+//	@Tags			conversations
+//	@Accept			json
+//	@Produce		json
+//	@Param			conversationID	path		int						true	"Conversation ID"
+//	@Param			payload			body		CreateMessagePayload	true	"Message payload"
+//	@Success		201				{object}	store.Message
+//	@Failure		400				{object}	error
+//	@Failure		403				{object}	error
+//	@Failure		404				{object}	error
+//	@Failure		500				{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationID}/messages [post]
+func (app *application) createMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateMessagePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	conversation := getConversationFromCtx(r)
+
+	message := &store.Message{
+		ConversationID: conversation.ID,
+		SenderID:       user.ID,
+		Content:        payload.Content,
+	}
+
+	// the store refuses a sender the other participant blocked
+	if err := app.store.Messages.Create(r.Context(), message); err != nil {
+		switch err {
+		case store.ErrBlocked:
+			app.errorForbidden(w, r)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, message); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// ReadConversation godoc
+//
+//	@Summary		Marks a conversation as read
+//	@Description	Sets the read receipt on every message received in the conversation
+//	@Tags			conversations
+//	@Produce		json
+//	@Param			conversationID	path		int		true	"Conversation ID"
+//	@Success		204				{string}	string	"Conversation read"
+//	@Failure		404				{object}	error
+//	@Failure		500				{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationID}/read [put]
+func (app *application) readConversationHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	conversation := getConversationFromCtx(r)
+
+	if err := app.store.Messages.MarkRead(r.Context(), conversation.ID, user.ID); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// canStartConversation allows a DM when the recipient accepts messages from
+// anyone, or when both users follow each other. A sender blocked by the
+// recipient can never start one.
+func (app *application) canStartConversation(ctx context.Context, sender, recipient *store.User) (bool, error) {
+	blocked, err := app.store.Blocks.IsBlocked(ctx, recipient.ID, sender.ID)
+	if err != nil || blocked {
+		return false, err
+	}
+
+	if recipient.AcceptsMessages {
+		return true, nil
+	}
+
+	return app.store.Followers.IsMutual(ctx, sender.ID, recipient.ID)
+}
+
+func (app *application) conversationsContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "conversationID"), 10, 64)
+		if err != nil {
+			app.errorBadRequest(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+
+		conversation, err := app.store.Conversations.GetByID(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.errorNotFound(w, r, err)
+			default:
+				app.errorInternalServer(w, r, err)
+			}
+			return
+		}
+
+		// conversations are private, so don't reveal that it exists
+		user := getUserFromContext(r)
+		if !conversation.HasParticipant(user.ID) {
+			app.errorNotFound(w, r, store.ErrNotFound)
+			return
+		}
+
+		ctx = context.WithValue(ctx, conversationKeyCtx, conversation)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getConversationFromCtx(r *http.Request) *store.Conversation {
+	conversation, _ := r.Context().Value(conversationKeyCtx).(*store.Conversation)
+	return conversation
+}
@@ -42,10 +42,34 @@ func (app *application) errorForbidden(w http.ResponseWriter, r *http.Request) {
 	writeJSONError(w, http.StatusForbidden, "forbidden")
 }		
 
+func (app *application) errorTwoFactorRequired(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warnw("two-factor authentication required", "method", r.Method, "path", r.URL.Path)
+	writeJSONError(w, http.StatusForbidden, "two-factor authentication required")
+}
+
+func (app *application) errorInsufficientScope(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warnw("insufficient token scope", "method", r.Method, "path", r.URL.Path)
+	writeJSONError(w, http.StatusForbidden, "the token scopes do not allow this request")
+}
+
 func (app *application) errorRateLimiterExceeded(w http.ResponseWriter, r *http.Request, retryAfter string) {
 	app.logger.Warnw("rate limit exceeded", "method", r.Method, "path", r.URL.Path)
 
 	w.Header().Set("Retry-After", retryAfter)
 
-	writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded, retry after: "+retryAfter)
-}
\ No newline at end of file
+	writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded, retry after: "+retryAfter+"s")
+}
+func (app *application) errorPreconditionFailed(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("precondition failed", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	writeJSONError(w, http.StatusPreconditionFailed, err.Error())
+}
+
+func (app *application) errorPreconditionRequired(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("precondition required", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	writeJSONError(w, http.StatusPreconditionRequired, err.Error())
+}
+
+func (app *application) errorUnprocessableEntity(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("unprocessable entity", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/codepnw/social/internal/store"
+)
+
+var (
+	errIfMatchRequired = errors.New("If-Match header is required")
+	errETagMismatch    = errors.New("resource has changed, fetch it again and retry")
+)
+
+// postETag names the version used for optimistic locking, which is what
+// If-Match compares, and a hash of the response body. The body also holds
+// the viewer's comments, polls and the quoted post, which change without a
+// new version, so If-None-Match compares all of it.
+func postETag(post *store.Post, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"v%d-%x"`, post.Version, sum[:8])
+}
+
+// etagVersion returns the post version a tag from postETag names.
+func etagVersion(tag string) (int, bool) {
+	tag = strings.TrimPrefix(strings.Trim(tag, `"`), "v")
+	version, _, _ := strings.Cut(tag, "-")
+
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return 0, false
+	}
+
+	return v, true
+}
+
+// writePost answers with the post and its ETag. A GET whose If-None-Match
+// names the same body gets 304 instead.
+func (app *application) writePost(w http.ResponseWriter, r *http.Request, status int, post *store.Post) error {
+	type envelope struct {
+		Data any `json:"data"`
+	}
+
+	body, err := json.Marshal(&envelope{Data: post})
+	if err != nil {
+		return err
+	}
+
+	// comments and votes differ per viewer, shared caches must not mix them
+	w.Header().Add("Vary", "Authorization")
+
+	etag := postETag(post, body)
+	if r.Method == http.MethodGet && notModified(w, r, etag) {
+		return nil
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// checkIfMatch enforces If-Match on writes to the post. It answers 428
+// when the header is missing and 412 when it names another version.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, post *store.Post) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		app.errorPreconditionRequired(w, r, errIfMatchRequired)
+		return false
+	}
+
+	if !versionMatch(header, post.Version) {
+		app.errorPreconditionFailed(w, r, errETagMismatch)
+		return false
+	}
+
+	return true
+}
+
+// versionMatch reports whether a comma-separated If-Match header names the
+// version. Edits only conflict with edits, so the body hash is not compared.
+func versionMatch(header string, version int) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+
+		// weak tags never match for If-Match
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+
+		if v, ok := etagVersion(tag); ok && v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified answers 304 when If-None-Match names the current body.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagMatch(header, etag) {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch reports whether a comma-separated If-None-Match header matches
+// etag, comparing weakly.
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+
+		if strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
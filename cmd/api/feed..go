@@ -44,13 +44,24 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	ctx := r.Context()
+	user := getUserFromContext(r)
 
-	feed, err := app.store.Posts.GetUserFeed(ctx, int64(155), fq)
+	feed, err := app.store.Posts.GetUserFeed(ctx, user.ID, fq)
 	if err != nil {
 		app.errorInternalServer(w, r, err)
 		return
 	}
 
+	posts := make([]*store.Post, len(feed))
+	for i := range feed {
+		posts[i] = &feed[i].Post
+	}
+
+	if err := app.attachPolls(ctx, posts, user.ID); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, feed); err != nil {
 		app.errorInternalServer(w, r, err)
 		return
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// clientIP is the address set by middleware.RealIP, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/codepnw/social/internal/store"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// idempotencyMiddleware makes POST endpoints safe to retry. The first
+// request with an Idempotency-Key runs and its response is stored, later
+// requests with the same key get that response back without running again.
+// Keys are scoped to the authenticated user, or to the client IP on public
+// endpoints, so it must come after AuthTokenMiddleware on protected routes.
+func (app *application) idempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			app.errorBadRequest(w, r, fmt.Errorf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			app.errorBadRequest(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(r)
+		fingerprint := requestFingerprint(r, body)
+
+		ctx := r.Context()
+
+		stored, err := app.store.Idempotency.Begin(ctx, scope, key, fingerprint, app.config.idempotency.ttl)
+		if err != nil {
+			switch err {
+			case store.ErrIdempotencyInProgress:
+				app.errorConflict(w, r, err)
+			case store.ErrIdempotencyMismatch:
+				app.errorUnprocessableEntity(w, r, err)
+			default:
+				app.errorInternalServer(w, r, err)
+			}
+			return
+		}
+
+		if stored != nil {
+			replayResponse(w, stored)
+			return
+		}
+
+		// finish bookkeeping even if the client went away, that is when
+		// it is most likely to retry
+		ctx = context.WithoutCancel(ctx)
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK, limit: app.config.idempotency.maxBodyBytes}
+		defer func() {
+			if err := recover(); err != nil {
+				app.releaseIdempotencyKey(ctx, scope, key)
+				panic(err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		// server errors are not final, let the client try again
+		if rec.status >= http.StatusInternalServerError {
+			app.releaseIdempotencyKey(ctx, scope, key)
+			return
+		}
+
+		resp := &store.IdempotentResponse{
+			StatusCode: rec.status,
+			Header:     rec.Header().Clone(),
+			Body:       rec.body.Bytes(),
+		}
+
+		// the key still guards against running the request twice, retries
+		// get the status without the body
+		if rec.truncated {
+			app.logger.Warnw("idempotent response too large to store", "scope", scope, "path", r.URL.Path)
+			resp.Header.Del("Content-Type")
+			resp.Header.Del("Content-Length")
+		}
+
+		if err := app.store.Idempotency.Complete(ctx, scope, key, resp); err != nil {
+			app.logger.Errorw("error storing idempotent response", "scope", scope, "error", err)
+			app.releaseIdempotencyKey(ctx, scope, key)
+		}
+	})
+}
+
+func (app *application) releaseIdempotencyKey(ctx context.Context, scope, key string) {
+	if err := app.store.Idempotency.Delete(ctx, scope, key); err != nil {
+		app.logger.Errorw("error releasing idempotency key", "scope", scope, "error", err)
+	}
+}
+
+func idempotencyScope(r *http.Request) string {
+	if user := getUserFromContext(r); user != nil {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+
+	return "ip:" + clientIP(r)
+}
+
+// requestFingerprint identifies the request a key was first used for, so
+// reusing the key for something else is caught instead of replayed.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replayResponse(w http.ResponseWriter, resp *store.IdempotentResponse) {
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
+
+// responseRecorder passes the response through while keeping a copy of up
+// to limit bytes of the body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	limit       int
+	truncated   bool
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+
+	if !rec.truncated {
+		if rec.body.Len()+len(b) > rec.limit {
+			rec.truncated = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+
+	return rec.ResponseWriter.Write(b)
+}
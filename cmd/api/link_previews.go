@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/codepnw/social/internal/unfurl"
+)
+
+type linkPreviewConfig struct {
+	enabled bool
+	// cacheTTL is how long a fetched preview, or a failed fetch, is reused
+	cacheTTL time.Duration
+	unfurl   unfurl.Config
+}
+
+// postLinkURL returns the link in the content that gets a preview.
+func postLinkURL(content string) *string {
+	url := unfurl.FirstURL(content)
+	if url == "" {
+		return nil
+	}
+
+	return &url
+}
+
+// fetchLinkPreview fills the preview cache for the URL of the post in the
+// background, posts linking to it show the preview once it is saved.
+func (app *application) fetchLinkPreview(postID int64, url *string) {
+	if !app.config.linkPreview.enabled || url == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.linkPreview.unfurl.Timeout+store.QueryTimeoutDuration)
+		defer cancel()
+
+		fresh, err := app.store.LinkPreviews.IsFresh(ctx, *url, app.config.linkPreview.cacheTTL)
+		if err != nil {
+			app.logger.Errorw("error reading link preview", "url", *url, "error", err)
+			return
+		}
+
+		if fresh {
+			return
+		}
+
+		var preview *store.LinkPreview
+
+		p, err := app.unfurler.Unfurl(ctx, *url)
+		if err != nil {
+			// saved as failed, so it is not fetched again until the cache expires
+			app.logger.Infow("could not unfurl link", "url", *url, "error", err)
+		} else {
+			preview = &store.LinkPreview{
+				URL:         p.URL,
+				Title:       p.Title,
+				Description: p.Description,
+				ImageURL:    p.ImageURL,
+				SiteName:    p.SiteName,
+			}
+		}
+
+		if err := app.store.LinkPreviews.Save(ctx, *url, preview); err != nil {
+			app.logger.Errorw("error saving link preview", "url", *url, "error", err)
+			return
+		}
+
+		// other posts with the link pick it up when their cache expires
+		app.invalidatePosts(ctx, postID)
+	}()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codepnw/social/internal/mailer"
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var errInvalidCredentials = errors.New("invalid email or password")
+
+type loginConfig struct {
+	// email throttles the guesses at one account, ip the guesses coming
+	// from one client across accounts
+	email     store.LoginPolicy
+	ip        store.LoginPolicy
+	unlockExp time.Duration
+}
+
+// loginThrottle holds the throttle keys of a login attempt. Keys exist for
+// any email, registered or not.
+type loginThrottle struct {
+	emailKey string
+	ipKey    string
+}
+
+func newLoginThrottle(email string, r *http.Request) loginThrottle {
+	return loginThrottle{
+		emailKey: "email:" + strings.ToLower(email),
+		ipKey:    "ip:" + clientIP(r),
+	}
+}
+
+// loginWait returns how long the attempt has to wait, the longest of the
+// email and the IP throttles.
+func (app *application) loginWait(ctx context.Context, lt loginThrottle) (time.Duration, error) {
+	now := time.Now()
+
+	email, err := app.store.Logins.GetThrottle(ctx, lt.emailKey)
+	if err != nil {
+		return 0, err
+	}
+
+	ip, err := app.store.Logins.GetThrottle(ctx, lt.ipKey)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(email.Wait(app.config.auth.login.email, now), ip.Wait(app.config.auth.login.ip, now)), nil
+}
+
+// loginFailed counts the failure against the email and the IP. The user is
+// nil when no account has the email; it is only used once the account gets
+// locked, and in the background so it does not show in the response time.
+func (app *application) loginFailed(ctx context.Context, r *http.Request, lt loginThrottle, user *store.User) error {
+	email, err := app.store.Logins.Fail(ctx, lt.emailKey, app.config.auth.login.email)
+	if err != nil {
+		return err
+	}
+
+	if _, err := app.store.Logins.Fail(ctx, lt.ipKey, app.config.auth.login.ip); err != nil {
+		return err
+	}
+
+	if email.Locked && user != nil {
+		event := &store.LoginEvent{
+			UserID:    user.ID,
+			Event:     store.LoginEventLockout,
+			IPAddress: clientIP(r),
+			UserAgent: r.UserAgent(),
+		}
+
+		go app.notifyLockout(user, lt.emailKey, *email.LockedUntil, event)
+	}
+
+	return nil
+}
+
+// notifyLockout records the lockout and mails the user a link that lifts it.
+func (app *application) notifyLockout(user *store.User, key string, lockedUntil time.Time, event *store.LoginEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), store.QueryTimeoutDuration*2)
+	defer cancel()
+
+	if err := app.store.Logins.CreateEvent(ctx, event); err != nil {
+		app.logger.Errorw("error recording lockout", "user_id", user.ID, "error", err)
+	}
+
+	plainToken := uuid.New().String()
+
+	if err := app.store.Logins.CreateUnlockToken(ctx, user.ID, key, plainToken, app.config.auth.login.unlockExp); err != nil {
+		app.logger.Errorw("error creating unlock token", "user_id", user.ID, "error", err)
+		return
+	}
+
+	isProdEnv := app.config.env == "production"
+
+	vars := struct {
+		Username    string
+		UnlockURL   string
+		LockedUntil string
+	}{
+		Username:    user.Username,
+		UnlockURL:   fmt.Sprintf("%s/unlock/%s", app.config.frontendURL, plainToken),
+		LockedUntil: lockedUntil.UTC().Format(time.RFC1123),
+	}
+
+	if _, err := app.mailer.Send(mailer.AccountLockedTemplate, user.Username, user.Email, vars, !isProdEnv); err != nil {
+		app.logger.Errorw("error sending lockout email", "user_id", user.ID, "error", err)
+	}
+}
+
+// resetLoginFailures clears the failures counted against the key. Only the
+// email and two-factor keys are reset on success, a valid account must not
+// reset the failures of its IP.
+func (app *application) resetLoginFailures(ctx context.Context, key string) {
+	if err := app.store.Logins.Reset(ctx, key); err != nil {
+		app.logger.Errorw("error resetting login failures", "key", key, "error", err)
+	}
+}
+
+// recordLogin adds a completed login to the history of the user.
+func (app *application) recordLogin(ctx context.Context, r *http.Request, user *store.User) {
+	event := &store.LoginEvent{
+		UserID:    user.ID,
+		Event:     store.LoginEventSuccess,
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	if err := app.store.Logins.CreateEvent(ctx, event); err != nil {
+		app.logger.Errorw("error recording login", "user_id", user.ID, "error", err)
+	}
+}
+
+func (app *application) errorLoginThrottled(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	app.errorRateLimiterExceeded(w, r, strconv.Itoa(ceilSeconds(wait)))
+}
+
+// UnlockAccount godoc
+//
+//	@Summary		Unlocks an account
+//	@Description	Lifts a login lockout with the token mailed to the user
+//	@Tags			authentication
+//	@Produce		json
+//	@Param			token	path		string	true	"Unlock token"
+//	@Success		204		{string}	string	"Account unlocked"
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/auth/unlock/{token} [put]
+func (app *application) unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	err := app.store.Logins.Unlock(r.Context(), token, clientIP(r), r.UserAgent())
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetLoginEvents godoc
+//
+//	@Summary		Fetches the user's login history
+//	@Description	Fetches the successful logins, lockouts and unlocks of the authenticated user
+//	@Tags			users
+//	@Produce		json
+//	@Param			limit	query		int	false	"Limit"
+//	@Param			offset	query		int	false	"Offset"
+//	@Success		200		{object}	[]store.LoginEvent
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/login-events [get]
+func (app *application) getLoginEventsHandler(w http.ResponseWriter, r *http.Request) {
+	fq := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "desc",
+	}
+
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	events, err := app.store.Logins.GetEvents(r.Context(), user.ID, fq)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, events); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
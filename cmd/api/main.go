@@ -12,6 +12,7 @@ import (
 	"github.com/codepnw/social/internal/ratelimiter"
 	"github.com/codepnw/social/internal/store"
 	"github.com/codepnw/social/internal/store/cache"
+	"github.com/codepnw/social/internal/unfurl"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
@@ -36,6 +37,8 @@ const version = "0.0.1"
 // @name						Authorization
 // @description
 func main() {
+	redisEnabled := env.GetBool("REDIS_ENABLED", false)
+
 	cfg := config{
 		addr:        env.GetString("ADDR", ":8080"),
 		apiURL:      env.GetString("EXTERNAL_URL", "localhost:8080"),
@@ -50,7 +53,23 @@ func main() {
 			addr:    env.GetString("REDIS_ADDR", "localhost:6379"),
 			pw:      env.GetString("REDIS_PW", ""),
 			db:      env.GetInt("REDIS_DB", 0), // 0 = no default DB
-			enabled: env.GetBool("REDIS_ENABLED", false),
+			enabled: redisEnabled,
+		},
+		cache: cache.Config{
+			// memory only, every replica would keep revoked sessions and
+			// permissions until they expire, so it has to be asked for
+			Enabled:    env.GetBool("CACHE_ENABLED", redisEnabled),
+			MemorySize: env.GetInt("CACHE_MEMORY_SIZE", 10000),
+			MemoryTTL:  time.Second * time.Duration(env.GetInt("CACHE_MEMORY_TTL", 10)),
+
+			UserTTL:       time.Second * time.Duration(env.GetInt("CACHE_USER_TTL", 60)),
+			RoleTTL:       time.Second * time.Duration(env.GetInt("CACHE_ROLE_TTL", 300)),
+			PermissionTTL: time.Second * time.Duration(env.GetInt("CACHE_PERMISSION_TTL", 300)),
+			PostTTL:       time.Second * time.Duration(env.GetInt("CACHE_POST_TTL", 60)),
+			CommentTTL:    time.Second * time.Duration(env.GetInt("CACHE_COMMENT_TTL", 30)),
+			SessionTTL:    time.Second * time.Duration(env.GetInt("CACHE_SESSION_TTL", 300)),
+			NegativeTTL:   time.Second * time.Duration(env.GetInt("CACHE_NEGATIVE_TTL", 10)),
+			RefreshAhead:  0.1,
 		},
 		env: env.GetString("ENV", "development"),
 		mail: mailConfig{
@@ -69,12 +88,73 @@ func main() {
 				secret: env.GetString("AUTH_TOKEN_SECRET", "example"),
 				exp:    time.Hour * 24 * 3, // 3 days
 				iss:    "gosocial",
+				mfaExp: time.Minute * 5,
+			},
+			login: loginConfig{
+				email: store.LoginPolicy{
+					Threshold:  env.GetInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+					Window:     time.Minute * 15,
+					Lockout:    time.Minute * 15,
+					DelayAfter: 3,
+					MaxDelay:   time.Second * 30,
+				},
+				// shared addresses (NAT, offices) see many users' typos
+				ip: store.LoginPolicy{
+					Threshold:  env.GetInt("LOGIN_IP_LOCKOUT_THRESHOLD", 50),
+					Window:     time.Minute * 15,
+					Lockout:    time.Minute * 15,
+					DelayAfter: 20,
+					MaxDelay:   time.Second * 10,
+				},
+				unlockExp: time.Hour * 24,
 			},
 		},
 		rateLimiter: ratelimiter.Config{
 			RequestsPerTimeFrame: env.GetInt("RATELIMITER_REQUESTS_COUNT", 20),
 			TimeFrame:            time.Second * 5,
 			Enabled:              env.GetBool("RATELIMITER_ENABLED", true),
+			Algorithm:            env.GetString("RATELIMITER_ALGORITHM", ratelimiter.AlgorithmSlidingWindow),
+			Distributed:          env.GetBool("RATELIMITER_DISTRIBUTED", false),
+			FailOpen:             env.GetBool("RATELIMITER_FAIL_OPEN", true),
+			Policies: map[string]ratelimiter.Policy{
+				rateLimitLogin:    {Limit: 10, Window: time.Minute},
+				rateLimitRegister: {Limit: 5, Window: time.Minute * 15},
+				rateLimitRead:     {Limit: env.GetInt("RATELIMITER_READ_COUNT", 300), Window: time.Minute},
+				rateLimitWrite:    {Limit: env.GetInt("RATELIMITER_WRITE_COUNT", 60), Window: time.Minute},
+				// a flood guard in front of authentication, well above what
+				// the users behind one address do
+				rateLimitIP: {Limit: env.GetInt("RATELIMITER_IP_COUNT", 1200), Window: time.Minute},
+			},
+			RoleMultipliers: map[string]float64{
+				"moderator": 2,
+				"admin":     5,
+			},
+		},
+		idempotency: idempotencyConfig{
+			ttl:          time.Hour * 24,
+			maxBodyBytes: 64 << 10, // 64 KB
+		},
+		scheduler: schedulerConfig{
+			interval:  time.Second * 30,
+			batchSize: env.GetInt("SCHEDULER_BATCH_SIZE", 100),
+
+			trendingInterval: time.Minute * 5,
+			purgeInterval:    time.Hour,
+		},
+		linkPreview: linkPreviewConfig{
+			enabled:  env.GetBool("LINK_PREVIEWS_ENABLED", true),
+			cacheTTL: time.Hour * 24,
+			unfurl: unfurl.Config{
+				Timeout:      time.Second * 5,
+				MaxBodyBytes: 512 << 10, // 512 KB
+				MaxRedirects: 3,
+				UserAgent:    "gosocial-unfurl/" + version,
+			},
+		},
+		oauth: oauthConfig{
+			stateExp:  time.Minute * 10,
+			timeout:   time.Second * 10,
+			providers: oauthProvidersFromEnv(),
 		},
 	}
 
@@ -105,13 +185,21 @@ func main() {
 	}
 
 	// Rate limiter
-	rateLimiter := ratelimiter.NewFixedWindowLimiter(
-		cfg.rateLimiter.RequestsPerTimeFrame,
-		cfg.rateLimiter.TimeFrame,
-	)
+	rateLimiters, err := newRateLimiters(cfg.rateLimiter, rdb)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	store := store.NewStorage(db)
-	cacheStorage := cache.NewRedisStorage(rdb)
+	// reads go on to the database when Redis fails
+	cfg.cache.OnRedisError = func(err error) {
+		logger.Warnw("cache redis error", "error", err)
+	}
+	// without Redis the cache keeps to memory
+	cacheStorage := cache.NewStorage(rdb, store, cfg.cache)
+	if cfg.cache.Enabled && rdb == nil {
+		logger.Warn("cache is memory only: replicas do not see each other's changes, run a single replica or enable redis")
+	}
 
 	// Mailer
 	mailer := mailer.NewSendgrid(cfg.mail.sendGrid.apiKey, cfg.mail.fromEmail)
@@ -129,7 +217,10 @@ func main() {
 		logger:        logger,
 		mailer:        mailer,
 		authenticator: jwtAuthenticator,
-		rateLimiter:   rateLimiter,
+		rateLimiters:  rateLimiters,
+		unfurler:      unfurl.New(cfg.linkPreview.unfurl),
+
+		oauthProviders: newOAuthProviders(cfg, logger),
 	}
 
 	// Metrics collected
@@ -140,6 +231,9 @@ func main() {
 	expvar.Publish("goroutines", expvar.Func(func() any {
 		return runtime.NumGoroutine()
 	}))
+	expvar.Publish("cache", expvar.Func(func() any {
+		return cacheStorage.Stats()
+	}))
 
 	mux := app.mount()
 
@@ -5,8 +5,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/codepnw/social/internal/store"
 	"github.com/golang-jwt/jwt/v5"
@@ -52,6 +54,17 @@ func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 }
 
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return app.authenticate(next, true)
+}
+
+// TwoFactorSetupAuthMiddleware authenticates like AuthTokenMiddleware, but
+// lets in the users whose role requires two-factor authentication before
+// they have it, so they can set it up.
+func (app *application) TwoFactorSetupAuthMiddleware(next http.Handler) http.Handler {
+	return app.authenticate(next, false)
+}
+
+func (app *application) authenticate(next http.Handler, enforce2FA bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -66,6 +79,11 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 		}
 
 		token := parts[1]
+		if store.IsPersonalToken(token) {
+			app.authenticatePersonalToken(w, r, next, token, enforce2FA)
+			return
+		}
+
 		jwtToken, err := app.authenticator.ValidateToken(token)
 		if err != nil {
 			app.errorUnauthorized(w, r, err)
@@ -74,6 +92,11 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 
 		claims, _ := jwtToken.Claims.(jwt.MapClaims)
 
+		if typ, _ := claims["typ"].(string); typ != tokenTypeAccess {
+			app.errorUnauthorized(w, r, fmt.Errorf("not an access token"))
+			return
+		}
+
 		userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
 		if err != nil {
 			app.errorUnauthorized(w, r, err)
@@ -88,12 +111,53 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// tokens issued before a force-logout are no longer valid
+		if user.TokensValidAfter != nil {
+			issuedAt, err := claims.GetIssuedAt()
+			if err != nil || issuedAt == nil || issuedAt.Before(*user.TokensValidAfter) {
+				app.errorUnauthorized(w, r, fmt.Errorf("token has been revoked"))
+				return
+			}
+		}
+
+		mfa, _ := claims["mfa"].(bool)
+		if enforce2FA && user.Role.Require2FA && !mfa {
+			app.errorTwoFactorRequired(w, r)
+			return
+		}
+
+		// tokens without a session could not be revoked, they are not taken.
+		// This logs out every login from before sessions, once.
+		sid, _ := claims["sid"].(string)
+		if sid == "" {
+			app.errorUnauthorized(w, r, fmt.Errorf("token has no session"))
+			return
+		}
+
+		session, err := app.getSession(ctx, sid)
+		if err != nil && err != store.ErrNotFound {
+			app.errorInternalServer(w, r, err)
+			return
+		}
+
+		now := time.Now()
+		if session == nil || session.UserID != user.ID || !session.Active(now) {
+			app.errorUnauthorized(w, r, fmt.Errorf("session has been revoked"))
+			return
+		}
+
+		if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+			app.touchSession(*session)
+		}
+
 		ctx = context.WithValue(ctx, userKeyCtx, user)
+		ctx = context.WithValue(ctx, sessionKeyCtx, session)
+		ctx = context.WithValue(ctx, mfaKeyCtx, mfa)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func (app *application) checkPostOwnership(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+func (app *application) checkPostOwnership(permission string, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := getUserFromContext(r)
 		post := getPostFromCtx(r)
@@ -104,8 +168,7 @@ func (app *application) checkPostOwnership(requiredRole string, next http.Handle
 			return
 		}
 
-		// role precedence check
-		allowed, err := app.checkRolePrecedence(r.Context(), user, requiredRole)
+		allowed, err := app.hasPermission(r.Context(), user, permission)
 		if err != nil {
 			app.errorInternalServer(w, r, err)
 			return
@@ -120,48 +183,33 @@ func (app *application) checkPostOwnership(requiredRole string, next http.Handle
 	})
 }
 
-func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
-	role, err := app.store.Roles.GetByName(ctx, roleName)
-	if err != nil {
-		return false, err
-	}
+// requirePermission only lets through users whose role grants permission.
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserFromContext(r)
 
-	return user.Role.Level >= role.Level, nil
-}
+			allowed, err := app.hasPermission(r.Context(), user, permission)
+			if err != nil {
+				app.errorInternalServer(w, r, err)
+				return
+			}
+
+			if !allowed {
+				app.errorForbidden(w, r)
+				return
+			}
 
-func (app *application) getUser(ctx context.Context, userID int64) (*store.User, error) {
-	if !app.config.redisCfg.enabled {
-		return app.store.Users.GetByID(ctx, userID)
+			next.ServeHTTP(w, r)
+		})
 	}
+}
 
-	user, err := app.cacheStorage.Users.Get(ctx, userID)
+func (app *application) hasPermission(ctx context.Context, user *store.User, permission string) (bool, error) {
+	permissions, err := app.getRolePermissions(ctx, user.RoleID)
 	if err != nil {
-		return nil, err
-	}	
-
-	if user == nil {
-		user, err = app.store.Users.GetByID(ctx, userID)
-		if err != nil {
-			return nil, err
-		}
-
-		if err := app.cacheStorage.Users.Set(ctx, user); err != nil {
-			return nil, err
-		}
+		return false, err
 	}
 
-	return user, nil
+	return slices.Contains(permissions, permission), nil
 }
-
-func (app *application) RateLimiterMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.rateLimiter.Enabled {
-			if allow, retryAfter := app.rateLimiter.Allow(r.RemoteAddr); !allow {
-				app.errorRateLimiterExceeded(w, r, retryAfter.String())
-				return
-			}
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
\ No newline at end of file
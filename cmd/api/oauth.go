@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codepnw/social/internal/env"
+	"github.com/codepnw/social/internal/oauth"
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+var (
+	errEmailNotVerified   = errors.New("the provider did not return a verified email")
+	errOAuthStateMismatch = errors.New("oauth state was not started by this browser")
+)
+
+const (
+	// oauthStateCookie ties a sign in to the browser that started it, so a
+	// callback with someone else's code and state is refused
+	oauthStateCookie = "oauth_state"
+	oauthCookiePath  = "/v1/auth/oauth/"
+)
+
+type oauthConfig struct {
+	// stateExp is how long a sign in has to come back from the provider
+	stateExp  time.Duration
+	timeout   time.Duration
+	providers []oauth.ProviderConfig
+}
+
+// oauthProvidersFromEnv reads the providers named in OAUTH_PROVIDERS, a
+// comma separated list, each configured by OAUTH_<NAME>_* variables.
+func oauthProvidersFromEnv() []oauth.ProviderConfig {
+	var providers []oauth.ProviderConfig
+
+	for _, name := range strings.Split(env.GetString("OAUTH_PROVIDERS", ""), ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OAUTH_" + strings.ToUpper(name) + "_"
+
+		providers = append(providers, oauth.ProviderConfig{
+			Name:         name,
+			ClientID:     env.GetString(prefix+"CLIENT_ID", ""),
+			ClientSecret: env.GetString(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  env.GetString(prefix+"REDIRECT_URL", ""),
+			Scopes:       strings.Fields(env.GetString(prefix+"SCOPES", "openid email profile")),
+			Issuer:       env.GetString(prefix+"ISSUER", ""),
+			AuthURL:      env.GetString(prefix+"AUTH_URL", ""),
+			TokenURL:     env.GetString(prefix+"TOKEN_URL", ""),
+			UserInfoURL:  env.GetString(prefix+"USERINFO_URL", ""),
+			EmailsURL:    env.GetString(prefix+"EMAILS_URL", ""),
+		})
+	}
+
+	return providers
+}
+
+// newOAuthProviders sets up the configured providers. A provider that
+// cannot be set up is left out, so one unreachable issuer does not keep
+// the API from starting.
+func newOAuthProviders(cfg config, logger *zap.SugaredLogger) map[string]*oauth.Provider {
+	providers := map[string]*oauth.Provider{}
+
+	for _, pc := range cfg.oauth.providers {
+		if pc.RedirectURL == "" {
+			pc.RedirectURL = fmt.Sprintf("%s/oauth/%s/callback", cfg.frontendURL, pc.Name)
+		}
+
+		p, err := oauth.NewProvider(context.Background(), pc, cfg.oauth.timeout)
+		if err != nil {
+			logger.Errorw("error setting up oauth provider", "provider", pc.Name, "error", err)
+			continue
+		}
+
+		providers[pc.Name] = p
+	}
+
+	return providers
+}
+
+type OAuthStart struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+}
+
+type OAuthCallbackPayload struct {
+	Code  string `json:"code" validate:"required,max=2048"`
+	State string `json:"state" validate:"required,max=255"`
+}
+
+// StartOAuth godoc
+//
+//	@Summary		Starts a social login
+//	@Description	Returns the provider URL to send the user to and sets the state cookie, so it must be called with credentials. The provider redirects back to the frontend, which posts the code and state to the callback, again with credentials.
+//	@Tags			authentication
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider name"
+//	@Success		200			{object}	OAuthStart
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Router			/auth/oauth/{provider} [get]
+func (app *application) startOAuthHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oauthProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.errorNotFound(w, r, oauth.ErrUnknownProvider)
+		return
+	}
+
+	state, err := oauth.RandomString()
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	nonce, err := oauth.RandomString()
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	pending := &store.OAuthState{
+		Provider:     provider.Name(),
+		CodeVerifier: oauth.GenerateVerifier(),
+		Nonce:        nonce,
+	}
+
+	if err := app.store.Identities.CreateState(r.Context(), state, pending, app.config.oauth.stateExp); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	app.setOAuthStateCookie(w, state, int(app.config.oauth.stateExp.Seconds()))
+
+	start := OAuthStart{
+		URL:   provider.AuthCodeURL(state, pending.CodeVerifier, pending.Nonce),
+		State: state,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, start); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// OAuthCallback godoc
+//
+//	@Summary		Finishes a social login
+//	@Description	Exchanges the code the provider returned for a token, linking the identity to the user with its verified email or creating a user
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			provider	path		string					true	"Provider name"
+//	@Param			payload		body		OAuthCallbackPayload	true	"Code and state"
+//	@Success		201			{string}	string					"Token"
+//	@Success		202			{object}	TwoFactorChallenge		"Two-factor authentication required"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error
+//	@Failure		422			{object}	error	"No verified email"
+//	@Failure		500			{object}	error
+//	@Router			/auth/oauth/{provider}/callback [post]
+func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oauthProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.errorNotFound(w, r, oauth.ErrUnknownProvider)
+		return
+	}
+
+	var payload OAuthCallbackPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	// an attacker can finish a sign in of their own up to here, and send
+	// the victim's browser to the frontend with its code and state
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(payload.State)) != 1 {
+		app.errorUnauthorized(w, r, errOAuthStateMismatch)
+		return
+	}
+	app.setOAuthStateCookie(w, "", -1)
+
+	ctx := r.Context()
+
+	pending, err := app.store.Identities.ConsumeState(ctx, payload.State)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorUnauthorized(w, r, fmt.Errorf("unknown or expired oauth state"))
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	if pending.Provider != provider.Name() {
+		app.errorUnauthorized(w, r, fmt.Errorf("oauth state is for another provider"))
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, payload.Code, pending.CodeVerifier, pending.Nonce)
+	if err != nil {
+		app.errorUnauthorized(w, r, err)
+		return
+	}
+
+	userID, err := app.oauthUserID(ctx, identity)
+	if err != nil {
+		switch err {
+		case errEmailNotVerified:
+			app.errorUnprocessableEntity(w, r, err)
+		case store.ErrDuplicateEmail, store.ErrConflict:
+			app.errorConflict(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorUnauthorized(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// setOAuthStateCookie sets the state cookie, or deletes it when maxAge is
+// negative. The frontend may be on another site, so it has to be
+// SameSite=None, which browsers only accept on secure cookies.
+func (app *application) setOAuthStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     oauthCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
+
+// oauthUserID finds the user of the identity: the one it is linked to, else
+// the one with its email, else a new one.
+func (app *application) oauthUserID(ctx context.Context, identity *oauth.Identity) (int64, error) {
+	userID, err := app.store.Identities.GetUserID(ctx, identity.Provider, identity.Subject)
+	if err != store.ErrNotFound {
+		return userID, err
+	}
+
+	// an unverified email could belong to anyone
+	if identity.Email == "" || !identity.EmailVerified {
+		return 0, errEmailNotVerified
+	}
+
+	userID, err = app.store.Identities.LinkByEmail(ctx, identity.Provider, identity.Subject, identity.Email)
+	if err == nil {
+		// linking activates invited users, cached as missing until now
+		app.invalidateUser(ctx, userID)
+	}
+	if err != store.ErrNotFound {
+		return userID, err
+	}
+
+	return app.provisionOAuthUser(ctx, identity)
+}
+
+// provisionOAuthUser creates the user of an identity. The password is
+// random, the user signs in with the provider.
+func (app *application) provisionOAuthUser(ctx context.Context, identity *oauth.Identity) (int64, error) {
+	password, err := oauth.RandomString()
+	if err != nil {
+		return 0, err
+	}
+
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = truncateRunes(strings.TrimSpace(base), 90)
+
+	user := &store.User{
+		Username: base,
+		Email:    identity.Email,
+	}
+
+	if err := user.Password.Set(password); err != nil {
+		return 0, err
+	}
+
+	// the provider username may be taken here, try a few suffixes
+	for attempt := 0; ; attempt++ {
+		err := app.store.Identities.CreateUser(ctx, user, identity.Provider, identity.Subject)
+		if err == nil {
+			app.invalidateUser(ctx, user.ID)
+		}
+		if err != store.ErrDuplicateUsername || attempt == 3 {
+			return user.ID, err
+		}
+
+		suffix, err := oauth.RandomString()
+		if err != nil {
+			return 0, err
+		}
+		user.Username = base + "-" + strings.ToLower(suffix[:6])
+	}
+}
+
+func truncateRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codepnw/social/internal/oauth"
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// fakeIdentities keeps the pending sign ins in memory and knows no users.
+type fakeIdentities struct {
+	states   map[string]*store.OAuthState
+	consumed int
+}
+
+func (f *fakeIdentities) CreateState(ctx context.Context, state string, st *store.OAuthState, exp time.Duration) error {
+	f.states[state] = st
+	return nil
+}
+
+func (f *fakeIdentities) ConsumeState(ctx context.Context, state string) (*store.OAuthState, error) {
+	f.consumed++
+
+	st, ok := f.states[state]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	delete(f.states, state)
+
+	return st, nil
+}
+
+func (f *fakeIdentities) GetUserID(ctx context.Context, provider, subject string) (int64, error) {
+	return 0, store.ErrNotFound
+}
+
+func (f *fakeIdentities) LinkByEmail(ctx context.Context, provider, subject, email string) (int64, error) {
+	return 0, store.ErrNotFound
+}
+
+func (f *fakeIdentities) CreateUser(ctx context.Context, user *store.User, provider, subject string) error {
+	return store.ErrConflict
+}
+
+func (f *fakeIdentities) PurgeExpiredStates(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func newOAuthTestApp(t *testing.T) (*application, *fakeIdentities) {
+	t.Helper()
+
+	// a plain OAuth2 provider, set up without calling it
+	provider, err := oauth.NewProvider(context.Background(), oauth.ProviderConfig{
+		Name:     "mock",
+		ClientID: "client",
+		AuthURL:  "https://provider.example.com/authorize",
+		TokenURL: "https://provider.example.com/token",
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identities := &fakeIdentities{states: map[string]*store.OAuthState{}}
+
+	app := &application{
+		config: config{
+			frontendURL: "https://app.example.com",
+			oauth:       oauthConfig{stateExp: time.Minute * 10},
+		},
+		store:          store.Storage{Identities: identities},
+		logger:         zap.NewNop().Sugar(),
+		oauthProviders: map[string]*oauth.Provider{"mock": provider},
+	}
+
+	return app, identities
+}
+
+func (app *application) oauthTestRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(app.corsMiddleware)
+	r.Get("/v1/auth/oauth/{provider}", app.startOAuthHandler)
+	r.Post("/v1/auth/oauth/{provider}/callback", app.oauthCallbackHandler)
+
+	return r
+}
+
+func stateCookie(t *testing.T, rr *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == oauthStateCookie {
+			return c
+		}
+	}
+
+	t.Fatal("no state cookie set")
+	return nil
+}
+
+func TestStartOAuthSetsStateCookie(t *testing.T) {
+	app, identities := newOAuthTestApp(t)
+
+	rr := httptest.NewRecorder()
+	app.oauthTestRouter().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/auth/oauth/mock", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	cookie := stateCookie(t, rr)
+
+	if _, ok := identities.states[cookie.Value]; !ok {
+		t.Error("the state cookie is not the stored state")
+	}
+	if !strings.Contains(rr.Body.String(), `"state":"`+cookie.Value+`"`) {
+		t.Error("the state cookie is not the returned state")
+	}
+
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteNoneMode {
+		t.Errorf("cookie = %+v, want HttpOnly, Secure and SameSite=None", cookie)
+	}
+	if cookie.Path != oauthCookiePath || cookie.MaxAge != 600 {
+		t.Errorf("cookie path %q max age %d, want %q and 600", cookie.Path, cookie.MaxAge, oauthCookiePath)
+	}
+}
+
+func TestOAuthCallbackState(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+		// state is posted to the callback, the started one unless set
+		state        string
+		wantStatus   int
+		wantConsumed bool
+	}{
+		{name: "no cookie", wantStatus: http.StatusUnauthorized},
+		{name: "cookie of another sign in", cookie: "another", wantStatus: http.StatusUnauthorized},
+		{name: "state of another sign in", cookie: "started", state: "another", wantStatus: http.StatusUnauthorized},
+		// the code is fake, so the exchange with the provider fails after
+		// the state is used up
+		{name: "matching cookie", cookie: "started", wantStatus: http.StatusUnauthorized, wantConsumed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, identities := newOAuthTestApp(t)
+			app.oauthProviders["mock"] = mustPlainProvider(t, "mock")
+
+			identities.states["started"] = &store.OAuthState{Provider: "mock", CodeVerifier: "verifier"}
+
+			state := tt.state
+			if state == "" {
+				state = "started"
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/auth/oauth/mock/callback",
+				strings.NewReader(`{"code": "code", "state": "`+state+`"}`))
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: tt.cookie})
+			}
+
+			rr := httptest.NewRecorder()
+			app.oauthTestRouter().ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+
+			if consumed := identities.consumed > 0; consumed != tt.wantConsumed {
+				t.Errorf("state consumed = %v, want %v", consumed, tt.wantConsumed)
+			}
+
+			if tt.wantConsumed {
+				if cookie := stateCookie(t, rr); cookie.MaxAge >= 0 {
+					t.Errorf("cookie max age = %d, want it deleted", cookie.MaxAge)
+				}
+			}
+		})
+	}
+}
+
+// mustPlainProvider returns a provider whose token endpoint refuses every
+// code.
+func mustPlainProvider(t *testing.T, name string) *oauth.Provider {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	provider, err := oauth.NewProvider(context.Background(), oauth.ProviderConfig{
+		Name:     name,
+		ClientID: "client",
+		AuthURL:  srv.URL + "/authorize",
+		TokenURL: srv.URL + "/token",
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+func TestOAuthUserIDUnverifiedEmail(t *testing.T) {
+	app, _ := newOAuthTestApp(t)
+
+	for _, identity := range []*oauth.Identity{
+		{Provider: "mock", Subject: "1", Email: "ada@example.com", EmailVerified: false},
+		{Provider: "mock", Subject: "2", EmailVerified: true},
+	} {
+		if _, err := app.oauthUserID(context.Background(), identity); err != errEmailNotVerified {
+			t.Errorf("oauthUserID(%+v) error = %v, want %v", identity, err, errEmailNotVerified)
+		}
+	}
+}
+
+func TestOAuthCORS(t *testing.T) {
+	app, _ := newOAuthTestApp(t)
+
+	tests := []struct {
+		name            string
+		origin          string
+		path            string
+		wantOrigin      string
+		wantCredentials bool
+	}{
+		{name: "frontend on social login", origin: "https://app.example.com", path: "/v1/auth/oauth/mock/callback", wantOrigin: "https://app.example.com", wantCredentials: true},
+		{name: "another site on social login", origin: "https://evil.example.com", path: "/v1/auth/oauth/mock/callback"},
+		{name: "another site on the API", origin: "https://evil.example.com", path: "/v1/posts/1", wantOrigin: "https://evil.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+			rr := httptest.NewRecorder()
+			app.corsMiddleware(http.NotFoundHandler()).ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+
+			if got := rr.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tt.wantCredentials {
+				t.Errorf("credentials allowed = %v, want %v", got, tt.wantCredentials)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type tokenResourceKey string
+
+const tokenResourceKeyCtx tokenResourceKey = "tokenResource"
+
+type CreatePersonalTokenPayload struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=read write posts:write users:write tags:write messages:write reports:write"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"required,min=1,max=365"`
+}
+
+type PersonalTokenWithSecret struct {
+	*store.PersonalAccessToken
+	// Token is only shown this once
+	Token string `json:"token"`
+}
+
+// tokenScopeMiddleware lets personal access tokens into the routes after
+// it, as far as their scopes cover the resource. Routes without it only
+// take JWTs.
+func (app *application) tokenScopeMiddleware(resource string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), tokenResourceKeyCtx, resource)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (app *application) authenticatePersonalToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string, enforce2FA bool) {
+	ctx := r.Context()
+
+	resource, _ := ctx.Value(tokenResourceKeyCtx).(string)
+	if resource == "" {
+		app.errorInsufficientScope(w, r)
+		return
+	}
+
+	pat, stale, err := app.store.PersonalTokens.GetByToken(ctx, token)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorUnauthorized(w, r, fmt.Errorf("invalid or expired personal access token"))
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+	if !pat.Allows(resource, write) {
+		app.errorInsufficientScope(w, r)
+		return
+	}
+
+	user, err := app.getUser(ctx, pat.UserID)
+	if err != nil {
+		app.errorUnauthorized(w, r, err)
+		return
+	}
+
+	// a token stands in for its login, so it needs the second factor too
+	// once the role requires it, like the login does
+	if enforce2FA && user.Role.Require2FA && !pat.MFA {
+		app.errorTwoFactorRequired(w, r)
+		return
+	}
+
+	// recorded at most once a minute, a busy bot should not write on every
+	// request
+	if stale {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), store.QueryTimeoutDuration)
+			defer cancel()
+
+			if err := app.store.PersonalTokens.Touch(ctx, pat.ID); err != nil {
+				app.logger.Errorw("error recording token use", "token_id", pat.ID, "error", err)
+			}
+		}()
+	}
+
+	ctx = context.WithValue(ctx, userKeyCtx, user)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// CreatePersonalToken godoc
+//
+//	@Summary		Creates a personal access token
+//	@Description	Creates a scoped, expiring token for scripts and integrations. The token is only returned this once. Roles that require two-factor authentication only take tokens created by a login that passed it.
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreatePersonalTokenPayload	true	"Token payload"
+//	@Success		201		{object}	PersonalTokenWithSecret
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens [post]
+func (app *application) createPersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreatePersonalTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	plain, err := store.NewPersonalToken()
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	token := &store.PersonalAccessToken{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Scopes: payload.Scopes,
+		MFA:    getMFAFromContext(r),
+	}
+
+	exp := time.Hour * 24 * time.Duration(payload.ExpiresInDays)
+
+	if err := app.store.PersonalTokens.Create(r.Context(), token, plain, exp); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	resp := PersonalTokenWithSecret{
+		PersonalAccessToken: token,
+		Token:               plain,
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, resp); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// ListPersonalTokens godoc
+//
+//	@Summary		Lists the personal access tokens
+//	@Description	Lists the personal access tokens of the authenticated user, without their secrets
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	[]store.PersonalAccessToken
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens [get]
+func (app *application) listPersonalTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	tokens, err := app.store.PersonalTokens.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// RevokePersonalToken godoc
+//
+//	@Summary		Revokes a personal access token
+//	@Description	Revokes a personal access token of the authenticated user
+//	@Tags			users
+//	@Produce		json
+//	@Param			tokenID	path		int		true	"Token ID"
+//	@Success		204		{string}	string	"Token revoked"
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens/{tokenID} [delete]
+func (app *application) revokePersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "tokenID"), 10, 64)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if err := app.store.PersonalTokens.Delete(r.Context(), user.ID, id); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
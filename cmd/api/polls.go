@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/codepnw/social/internal/store"
+)
+
+const maxPollDuration = 7 * 24 * time.Hour
+
+type CreatePollPayload struct {
+	Options        []string  `json:"options" validate:"min=2,max=4,dive,required,max=100"`
+	MultipleChoice bool      `json:"multiple_choice"`
+	ClosesAt       time.Time `json:"closes_at" validate:"required"`
+}
+
+// newPoll checks the poll of a new post, it has to close after the post
+// goes out and within maxPollDuration of now.
+func newPoll(payload *CreatePollPayload, publishAt *time.Time) (*store.Poll, error) {
+	opensAt := time.Now()
+	if publishAt != nil {
+		opensAt = *publishAt
+	}
+
+	if !payload.ClosesAt.After(opensAt) {
+		return nil, errors.New("poll must close after the post is published")
+	}
+
+	if payload.ClosesAt.After(time.Now().Add(maxPollDuration)) {
+		return nil, errors.New("poll can run for at most 7 days")
+	}
+
+	poll := &store.Poll{
+		MultipleChoice: payload.MultipleChoice,
+		ClosesAt:       payload.ClosesAt.UTC().Format(time.RFC3339),
+		Options:        make([]store.PollOption, len(payload.Options)),
+	}
+	for i, text := range payload.Options {
+		poll.Options[i].Text = text
+	}
+
+	return poll, nil
+}
+
+type VotePollPayload struct {
+	OptionIDs []int64 `json:"option_ids" validate:"required,min=1,max=4,dive,gt=0"`
+}
+
+// VotePoll godoc
+//
+//	@Summary		Votes in a poll
+//	@Description	Casts the authenticated user's vote in the poll of a post, a user votes once
+//	@Tags			posts
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path		int				true	"Post ID"
+//	@Param			payload	body		VotePollPayload	true	"Chosen options"
+//	@Success		200		{object}	store.Poll
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/poll/vote [post]
+func (app *application) votePollHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VotePollPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	post := getPostFromCtx(r)
+	ctx := r.Context()
+
+	if post.Status != store.PostStatusPublished {
+		app.errorBadRequest(w, r, errors.New("poll is not open yet"))
+		return
+	}
+
+	slices.Sort(payload.OptionIDs)
+	optionIDs := slices.Compact(payload.OptionIDs)
+
+	if err := app.store.Polls.Vote(ctx, post.ID, user.ID, optionIDs); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, errors.New("post has no poll"))
+		case store.ErrConflict:
+			app.errorConflict(w, r, errors.New("already voted in this poll"))
+		case store.ErrPollClosed, store.ErrInvalidVote:
+			app.errorBadRequest(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	if err := app.attachPolls(ctx, []*store.Post{post}, user.ID); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, post.Poll); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+}
+
+// attachPolls loads the polls of a page of posts with a single query.
+func (app *application) attachPolls(ctx context.Context, posts []*store.Post, viewerID int64) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+
+	polls, err := app.store.Polls.GetByPostIDs(ctx, ids, viewerID)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range posts {
+		p.Poll = polls[p.ID]
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/codepnw/social/internal/store"
 	"github.com/go-chi/chi/v5"
@@ -15,15 +16,21 @@ type postKey string
 const postKeyCtx postKey = "post"
 
 type CreatePostPayload struct {
-	Title   string   `json:"title" validate:"required,max=100"`
-	Content string   `json:"content" validate:"required,max=200"`
-	Tags    []string `json:"tags"`
+	Title     string     `json:"title" validate:"required,max=100"`
+	Content   string     `json:"content" validate:"required,max=200"`
+	Tags      []string   `json:"tags"`
+	Status    string     `json:"status" validate:"omitempty,oneof=draft scheduled published"`
+	PublishAt *time.Time `json:"publish_at"`
+	// QuotedPostID makes the post a quote of another post
+	QuotedPostID *int64 `json:"quoted_post_id" validate:"omitempty,gt=0"`
+	// Poll attaches a poll of 2 to 4 options to the post
+	Poll *CreatePollPayload `json:"poll"`
 }
 
 // CreatePost godoc
 //
 //	@Summary		Creates a post
-//	@Description	Creates a post
+//	@Description	Creates a post, published right away unless saved as a draft or scheduled
 //	@Tags			posts
 //	@Accept			json
 //	@Produce		json
@@ -46,18 +53,75 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	user := getUserFromContext(r)
+	status := payload.Status
+	if status == "" {
+		status = store.PostStatusPublished
+	}
 
-	post := &store.Post{
-		Title:   payload.Title,
-		Content: payload.Content,
-		Tags:    payload.Tags,
-		UserID: user.ID,
+	publishAt, err := postSchedule(status, payload.PublishAt)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	var poll *store.Poll
+	if payload.Poll != nil {
+		poll, err = newPoll(payload.Poll, payload.PublishAt)
+		if err != nil {
+			app.errorBadRequest(w, r, err)
+			return
+		}
 	}
 
+	user := getUserFromContext(r)
 	ctx := r.Context()
 
+	if payload.QuotedPostID != nil {
+		quoted, err := app.getVisiblePost(ctx, *payload.QuotedPostID, user)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.errorBadRequest(w, r, errors.New("quoted post not found"))
+			default:
+				app.errorInternalServer(w, r, err)
+			}
+			return
+		}
+
+		if quoted.Status != store.PostStatusPublished {
+			app.errorBadRequest(w, r, errors.New("only published posts can be quoted"))
+			return
+		}
+	}
+
+	post := &store.Post{
+		Title:     payload.Title,
+		Content:   payload.Content,
+		Tags:      payload.Tags,
+		UserID:    user.ID,
+		Status:    status,
+		PublishAt: publishAt,
+
+		QuotedPostID: payload.QuotedPostID,
+		Poll:         poll,
+		LinkURL:      postLinkURL(payload.Content),
+	}
+
 	if err := app.store.Posts.Create(ctx, post); err != nil {
+		switch err {
+		case store.ErrInvalidTag:
+			app.errorBadRequest(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	// the id may have been cached as missing
+	app.invalidatePosts(ctx, post.ID)
+	app.fetchLinkPreview(post.ID, post.LinkURL)
+
+	if err := app.attachQuotedPost(ctx, post, user); err != nil {
 		app.errorInternalServer(w, r, err)
 		return
 	}
@@ -75,17 +139,20 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 //	@Tags			posts
 //	@Accept			json
 //	@Produce		json
-//	@Param			id	path		int	true	"Post ID"
-//	@Success		200	{object}	store.Post
-//	@Failure		404	{object}	error
+//	@Param			id				path		int		true	"Post ID"
+//	@Param			If-None-Match	header		string	false	"ETag of a cached copy"
+//	@Success		200				{object}	store.Post
+//	@Success		304				{string}	string	"Not modified"
+//	@Failure		404				{object}	error
 //	@Failure		500	{object}	error
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{id} [get]
 func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 	post := getPostFromCtx(r)
+	user := getUserFromContext(r)
 
 	// Get Comments
-	comments, err := app.store.Comments.GetByPostID(r.Context(), post.ID)
+	comments, err := app.getComments(r.Context(), post.ID, user.ID)
 	if err != nil {
 		app.errorInternalServer(w, r, err)
 		return
@@ -93,15 +160,27 @@ func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	post.Comments = comments
 
-	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
+	if err := app.attachQuotedPost(r.Context(), post, user); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.attachPolls(r.Context(), []*store.Post{post}, user.ID); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.writePost(w, r, http.StatusOK, post); err != nil {
 		app.errorInternalServer(w, r, err)
 		return
 	}
 }
 
 type UpdatePostPayload struct {
-	Title   *string `json:"title" validate:"omitempty,max=100"`
-	Content *string `json:"content" validate:"omitempty,max=200"`
+	Title     *string    `json:"title" validate:"omitempty,max=100"`
+	Content   *string    `json:"content" validate:"omitempty,max=200"`
+	Status    *string    `json:"status" validate:"omitempty,oneof=draft scheduled published"`
+	PublishAt *time.Time `json:"publish_at"`
 }
 
 // UpdatePost godoc
@@ -111,18 +190,25 @@ type UpdatePostPayload struct {
 //	@Tags			posts
 //	@Accept			json
 //	@Produce		json
-//	@Param			id		path		int					true	"Post ID"
-//	@Param			payload	body		UpdatePostPayload	true	"Post payload"
-//	@Success		200		{object}	store.Post
-//	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
+//	@Param			id			path		int					true	"Post ID"
+//	@Param			If-Match	header		string				true	"ETag of the version being edited"
+//	@Param			payload		body		UpdatePostPayload	true	"Post payload"
+//	@Success		200			{object}	store.Post
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		412			{object}	error
+//	@Failure		428			{object}	error
+//	@Failure		500			{object}	error
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{id} [patch]
 func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request) {
 	post := getPostFromCtx(r)
 
+	if !app.checkIfMatch(w, r, post) {
+		return
+	}
+
 	var payload UpdatePostPayload
 	if err := readJSON(w, r, &payload); err != nil {
 		app.errorBadRequest(w, r, err)
@@ -133,21 +219,71 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		app.errorBadRequest(w, r, err)
 		return
 	}
+
+	before := postAuditState(post)
 	
 	if payload.Content != nil {
 		post.Content = *payload.Content
+
+		// the old preview goes unless the link stayed the same
+		linkURL := postLinkURL(post.Content)
+		if linkURL == nil || post.LinkURL == nil || *linkURL != *post.LinkURL {
+			post.LinkURL = linkURL
+			post.LinkPreview = nil
+		}
 	}
 
 	if payload.Title != nil {
 		post.Title = *payload.Title
 	}
 
-	if err := app.store.Posts.Update(r.Context(), post); err != nil {
-		app.errorInternalServer(w, r, err)
+	if payload.Status != nil || payload.PublishAt != nil {
+		status := post.Status
+		if payload.Status != nil {
+			status = *payload.Status
+		}
+
+		if post.Status == store.PostStatusPublished && status != store.PostStatusPublished {
+			app.errorBadRequest(w, r, errors.New("a published post cannot go back to draft"))
+			return
+		}
+
+		publishAt, err := postSchedule(status, payload.PublishAt)
+		if err != nil {
+			app.errorBadRequest(w, r, err)
+			return
+		}
+
+		post.Status = status
+		post.PublishAt = publishAt
+	}
+
+	ctx := r.Context()
+
+	// changes to someone else's post are audited
+	if post.UserID != getUserFromContext(r).ID {
+		ctx = app.auditContext(r, store.AuditPostUpdate, "post", post.ID, before, postAuditState(post))
+	}
+
+	if err := app.store.Posts.Update(ctx, post); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		case store.ErrEditConflict:
+			app.errorPreconditionFailed(w, r, errETagMismatch)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
+	app.invalidatePosts(ctx, post.ID)
+
+	if payload.Content != nil {
+		app.fetchLinkPreview(post.ID, post.LinkURL)
+	}
+
+	if err := app.writePost(w, r, http.StatusOK, post); err != nil {
 		app.errorInternalServer(w, r, err)
 	}
 }
@@ -159,35 +295,118 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 //	@Tags			posts
 //	@Accept			json
 //	@Produce		json
-//	@Param			id	path		int	true	"Post ID"
-//	@Success		204	{object} string
-//	@Failure		404	{object}	error
-//	@Failure		500	{object}	error
+//	@Param			id			path		int		true	"Post ID"
+//	@Param			If-Match	header		string	true	"ETag of the version being deleted"
+//	@Success		204			{object}	string
+//	@Failure		404			{object}	error
+//	@Failure		412			{object}	error
+//	@Failure		428			{object}	error
+//	@Failure		500			{object}	error
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{id} [delete]
 func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request) {
-	idParam := chi.URLParam(r, "postID")
-	id, err := strconv.ParseInt(idParam, 10, 64)
-	if err != nil {
-		app.errorInternalServer(w, r, err)
+	post := getPostFromCtx(r)
+
+	if !app.checkIfMatch(w, r, post) {
 		return
 	}
 
 	ctx := r.Context()
 
-	if err := app.store.Posts.Delete(ctx, id); err != nil {
+	// deleting someone else's post is audited
+	if post.UserID != getUserFromContext(r).ID {
+		ctx = app.auditContext(r, store.AuditPostDelete, "post", post.ID, postAuditState(post), nil)
+	}
+
+	if err := app.store.Posts.Delete(ctx, post.ID, post.Version); err != nil {
 		switch {
 		case errors.Is(err, store.ErrNotFound):
 			app.errorNotFound(w, r, err)
+		case errors.Is(err, store.ErrEditConflict):
+			app.errorPreconditionFailed(w, r, errETagMismatch)
 		default:
 			app.errorInternalServer(w, r, err)
 		}
 		return
 	}
 
+	app.invalidatePosts(ctx, post.ID)
+	app.invalidateComments(ctx, post.ID)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetDrafts godoc
+//
+//	@Summary		Fetches the user's drafts
+//	@Description	Fetches the authenticated user's drafts and scheduled posts
+//	@Tags			posts
+//	@Produce		json
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			offset	query		int		false	"Offset"
+//	@Param			sort	query		string	false	"Sort"
+//	@Success		200		{object}	[]store.Post
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/drafts [get]
+func (app *application) getDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	fq := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "desc",
+	}
+
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	drafts, err := app.store.Posts.GetDrafts(r.Context(), user.ID, fq)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, drafts); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// postSchedule checks the publish time against the status of a post being
+// saved: scheduled posts need one in the future, the others none.
+func postSchedule(status string, publishAt *time.Time) (*string, error) {
+	if status != store.PostStatusScheduled {
+		if publishAt != nil {
+			return nil, errors.New("publish_at is only allowed for scheduled posts")
+		}
+		return nil, nil
+	}
+
+	if publishAt == nil || !publishAt.After(time.Now()) {
+		return nil, errors.New("scheduled posts need a publish_at in the future")
+	}
+
+	at := publishAt.UTC().Format(time.RFC3339)
+	return &at, nil
+}
+
+func postAuditState(post *store.Post) map[string]any {
+	return map[string]any{
+		"user_id": post.UserID,
+		"title":   post.Title,
+		"content": post.Content,
+	}
+}
+
 func (app *application) postsContextMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		idParam := chi.URLParam(r, "postID")
@@ -199,7 +418,7 @@ func (app *application) postsContextMiddleware(next http.Handler) http.Handler {
 
 		ctx := r.Context()
 
-		post, err := app.store.Posts.GetByID(ctx, id)
+		post, err := app.getVisiblePost(ctx, id, getUserFromContext(r))
 		if err != nil {
 			switch {
 			case errors.Is(err, store.ErrNotFound):
@@ -215,6 +434,81 @@ func (app *application) postsContextMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// getVisiblePost loads a post the viewer may see, and fails with
+// ErrNotFound for posts hidden from them.
+func (app *application) getVisiblePost(ctx context.Context, id int64, viewer *store.User) (*store.Post, error) {
+	post, err := app.getPost(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// drafts and scheduled posts are only visible to their author
+	if post.Status != store.PostStatusPublished && post.UserID != viewer.ID {
+		return nil, store.ErrNotFound
+	}
+
+	// authors who blocked the viewer are hidden from them
+	blocked, err := app.store.Blocks.IsBlocked(ctx, post.UserID, viewer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if blocked {
+		return nil, store.ErrNotFound
+	}
+
+	// private authors are only visible to their approved followers
+	visible, err := app.store.Followers.CanView(ctx, post.UserID, viewer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !visible {
+		return nil, store.ErrNotFound
+	}
+
+	return post, nil
+}
+
+// attachQuotedPost fills in the post a quote refers to, or a tombstone when
+// it was deleted or is hidden from the viewer.
+func (app *application) attachQuotedPost(ctx context.Context, post *store.Post, viewer *store.User) error {
+	if !post.IsQuote {
+		return nil
+	}
+
+	post.QuotedPost = &store.QuotedPost{Unavailable: true}
+	if post.QuotedPostID == nil {
+		return nil
+	}
+
+	quoted, err := app.getVisiblePost(ctx, *post.QuotedPostID, viewer)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	author, err := app.store.Users.GetByID(ctx, quoted.UserID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	post.QuotedPost = &store.QuotedPost{
+		ID:       quoted.ID,
+		UserID:   quoted.UserID,
+		Username: author.Username,
+		Title:    quoted.Title,
+		Content:  quoted.Content,
+	}
+
+	return nil
+}
+
 func getPostFromCtx(r *http.Request) *store.Post {
 	post, _ := r.Context().Value(postKeyCtx).(*store.Post)
 	return post
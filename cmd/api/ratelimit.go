@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codepnw/social/internal/ratelimiter"
+	"github.com/redis/go-redis/v9"
+)
+
+// Rate limit policies, see rateLimiter.Policies in main. The default
+// policy comes from RequestsPerTimeFrame and TimeFrame.
+const (
+	rateLimitDefault  = "default"
+	rateLimitLogin    = "login"
+	rateLimitRegister = "register"
+	rateLimitRead     = "read"
+	rateLimitWrite    = "write"
+	rateLimitIP       = "ip"
+)
+
+// newRateLimiters builds a limiter for every policy, each counting over the
+// policy's window.
+func newRateLimiters(cfg ratelimiter.Config, rdb *redis.Client) (map[string]ratelimiter.Limiter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	windows := map[string]time.Duration{rateLimitDefault: cfg.TimeFrame}
+	for name, p := range cfg.Policies {
+		windows[name] = p.Window
+	}
+
+	limiters := map[string]ratelimiter.Limiter{}
+	for name, window := range windows {
+		limiter, err := ratelimiter.New(cfg.Algorithm, window)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.Distributed {
+			if rdb == nil {
+				return nil, errors.New("distributed rate limiting needs redis, set REDIS_ENABLED")
+			}
+
+			// the in-memory limiter stays as the fallback
+			limiter = ratelimiter.NewRedisFixedWindowLimiter(rdb, window, cfg.FailOpen, limiter)
+		}
+
+		limiters[name] = limiter
+	}
+
+	return limiters, nil
+}
+
+// rateLimitMiddleware applies a policy. Authenticated users are counted by
+// ID, with their role's multiplier, so it must come after
+// AuthTokenMiddleware on protected routes. Everyone else is counted by IP,
+// which is how the ip policy counts every request ahead of routing.
+func (app *application) rateLimitMiddleware(policy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.config.rateLimiter.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, limit := app.rateLimitIdentity(r, policy)
+
+			res := app.rateLimiters[policy].Allow(key, limit)
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+			if !res.Allowed {
+				app.errorRateLimiterExceeded(w, r, strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readWriteRateLimitMiddleware applies the read policy to safe methods and
+// the write policy to the rest.
+func (app *application) readWriteRateLimitMiddleware(next http.Handler) http.Handler {
+	read := app.rateLimitMiddleware(rateLimitRead)(next)
+	write := app.rateLimitMiddleware(rateLimitWrite)(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			read.ServeHTTP(w, r)
+		default:
+			write.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (app *application) rateLimitIdentity(r *http.Request, policy string) (string, int) {
+	limit := app.config.rateLimiter.RequestsPerTimeFrame
+	if p, ok := app.config.rateLimiter.Policies[policy]; ok {
+		limit = p.Limit
+	}
+
+	user := getUserFromContext(r)
+	if user == nil {
+		return fmt.Sprintf("%s:ip:%s", policy, clientIP(r)), limit
+	}
+
+	if m, ok := app.config.rateLimiter.RoleMultipliers[user.Role.Name]; ok {
+		limit = max(1, int(float64(limit)*m))
+	}
+
+	return fmt.Sprintf("%s:user:%d", policy, user.ID), limit
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
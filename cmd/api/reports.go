@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type reportKey string
+
+const reportKeyCtx reportKey = "report"
+
+type CreateReportPayload struct {
+	TargetType string `json:"target_type" validate:"required,oneof=post comment user"`
+	TargetID   int64  `json:"target_id" validate:"required,gt=0"`
+	Reason     string `json:"reason" validate:"required,oneof=spam harassment hate_speech violence nudity misinformation other"`
+	Details    string `json:"details" validate:"max=1000"`
+}
+
+// CreateReport godoc
+//
+//	@Summary		Reports content
+//	@Description	Reports a post, comment or user to the moderators
+//	@Tags			reports
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateReportPayload	true	"Report payload"
+//	@Success		201		{object}	store.Report
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/reports [post]
+func (app *application) createReportHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateReportPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	report := &store.Report{
+		ReporterID: user.ID,
+		TargetType: payload.TargetType,
+		TargetID:   payload.TargetID,
+		Reason:     payload.Reason,
+		Details:    payload.Details,
+	}
+
+	if err := app.store.Reports.Create(r.Context(), report); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		case store.ErrConflict:
+			app.errorConflict(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, report); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// ListReports godoc
+//
+//	@Summary		Lists the moderation queue
+//	@Description	Lists reports by status, oldest first
+//	@Tags			moderation
+//	@Produce		json
+//	@Param			status	query		string	false	"Status (open, claimed, resolved, dismissed)"
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			offset	query		int		false	"Offset"
+//	@Success		200		{object}	[]store.Report
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports [get]
+func (app *application) listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	rq := store.ReportQuery{
+		Status: store.ReportStatusOpen,
+		Limit:  20,
+		Offset: 0,
+	}
+
+	rq, err := rq.Parse(r)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(rq); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	reports, err := app.store.Reports.List(r.Context(), rq)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, reports); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// GetReport godoc
+//
+//	@Summary		Fetches a report
+//	@Description	Fetches a report with its history of moderator actions
+//	@Tags			moderation
+//	@Produce		json
+//	@Param			reportID	path		int	true	"Report ID"
+//	@Success		200			{object}	store.Report
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports/{reportID} [get]
+func (app *application) getReportHandler(w http.ResponseWriter, r *http.Request) {
+	report := getReportFromCtx(r)
+
+	if err := app.jsonResponse(w, http.StatusOK, report); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// ClaimReport godoc
+//
+//	@Summary		Claims a report
+//	@Description	Assigns an open report to the authenticated moderator
+//	@Tags			moderation
+//	@Produce		json
+//	@Param			reportID	path		int		true	"Report ID"
+//	@Success		204			{string}	string	"Report claimed"
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports/{reportID}/claim [put]
+func (app *application) claimReportHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	report := getReportFromCtx(r)
+
+	if err := app.store.Reports.Claim(r.Context(), report.ID, user.ID); err != nil {
+		app.handleReportError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ResolveReportPayload struct {
+	Resolution string `json:"resolution" validate:"required,oneof=none hide_content suspend_user"`
+	Note       string `json:"note" validate:"max=1000"`
+}
+
+// ResolveReport godoc
+//
+//	@Summary		Resolves a report
+//	@Description	Closes a report, optionally hiding the content or suspending the account
+//	@Tags			moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			reportID	path		int						true	"Report ID"
+//	@Param			payload		body		ResolveReportPayload	true	"Resolution payload"
+//	@Success		204			{string}	string					"Report resolved"
+//	@Failure		400			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports/{reportID}/resolve [put]
+func (app *application) resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResolveReportPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	report := getReportFromCtx(r)
+
+	// report:moderate alone must not be a way around user:suspend
+	if payload.Resolution == store.ResolutionSuspendUser {
+		allowed, err := app.hasPermission(r.Context(), user, store.PermissionUserSuspend)
+		if err != nil {
+			app.errorInternalServer(w, r, err)
+			return
+		}
+
+		if !allowed {
+			app.errorForbidden(w, r)
+			return
+		}
+	}
+
+	after := map[string]any{"resolution": payload.Resolution, "note": payload.Note}
+	ctx := app.auditContext(r, store.AuditReportResolve, "report", report.ID, nil, after)
+
+	target, err := app.store.Reports.Resolve(ctx, report.ID, user.ID, payload.Resolution, payload.Note)
+	if err != nil {
+		app.handleReportError(w, r, err)
+		return
+	}
+
+	if target.PostID != 0 {
+		if report.TargetType == store.ReportTargetComment {
+			app.invalidateComments(ctx, target.PostID)
+		} else {
+			app.invalidatePosts(ctx, target.PostID)
+		}
+	}
+
+	if target.UserID != 0 {
+		app.invalidateUser(ctx, target.UserID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type DismissReportPayload struct {
+	Note string `json:"note" validate:"max=1000"`
+}
+
+// DismissReport godoc
+//
+//	@Summary		Dismisses a report
+//	@Description	Closes a report without taking action
+//	@Tags			moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			reportID	path		int						true	"Report ID"
+//	@Param			payload		body		DismissReportPayload	true	"Dismiss payload"
+//	@Success		204			{string}	string					"Report dismissed"
+//	@Failure		400			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports/{reportID}/dismiss [put]
+func (app *application) dismissReportHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DismissReportPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	report := getReportFromCtx(r)
+
+	after := map[string]any{"note": payload.Note}
+	ctx := app.auditContext(r, store.AuditReportDismiss, "report", report.ID, nil, after)
+
+	if err := app.store.Reports.Dismiss(ctx, report.ID, user.ID, payload.Note); err != nil {
+		app.handleReportError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) handleReportError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case store.ErrNotFound:
+		app.errorNotFound(w, r, err)
+	case store.ErrConflict:
+		app.errorConflict(w, r, errors.New("report is not available to this moderator"))
+	case store.ErrInvalidResolution:
+		app.errorBadRequest(w, r, err)
+	case store.ErrProtectedUser:
+		app.errorForbidden(w, r)
+	default:
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+func (app *application) reportsContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "reportID"), 10, 64)
+		if err != nil {
+			app.errorBadRequest(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+
+		report, err := app.store.Reports.GetByID(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.errorNotFound(w, r, err)
+			default:
+				app.errorInternalServer(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, reportKeyCtx, report)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getReportFromCtx(r *http.Request) *store.Report {
+	report, _ := r.Context().Value(reportKeyCtx).(*store.Report)
+	return report
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/codepnw/social/internal/store"
+)
+
+// RepostPost godoc
+//
+//	@Summary		Reposts a post
+//	@Description	Shares a post into the feeds of the authenticated user's followers
+//	@Tags			posts
+//	@Produce		json
+//	@Param			postID	path		int		true	"Post ID"
+//	@Success		204		{string}	string	"Post reposted"
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/repost [put]
+func (app *application) repostPostHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	post := getPostFromCtx(r)
+
+	if post.Status != store.PostStatusPublished {
+		app.errorBadRequest(w, r, errors.New("only published posts can be reposted"))
+		return
+	}
+
+	if err := app.store.Reposts.Create(r.Context(), user.ID, post.ID); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.errorConflict(w, r, errors.New("post is already reposted"))
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnrepostPost godoc
+//
+//	@Summary		Removes a repost
+//	@Description	Removes the authenticated user's repost of a post
+//	@Tags			posts
+//	@Produce		json
+//	@Param			postID	path		int		true	"Post ID"
+//	@Success		204		{string}	string	"Repost removed"
+//	@Failure		404		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/unrepost [put]
+func (app *application) unrepostPostHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	post := getPostFromCtx(r)
+
+	if err := app.store.Reposts.Delete(r.Context(), user.ID, post.ID); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
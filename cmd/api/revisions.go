@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/codepnw/social/internal/diff"
+	"github.com/codepnw/social/internal/store"
+)
+
+// GetPostRevisions godoc
+//
+//	@Summary		Fetches the edit history of a post
+//	@Description	Lists every version of a post, oldest first, ending with the current one. Only the author and holders of post:update:any can see it
+//	@Tags			posts
+//	@Produce		json
+//	@Param			postID	path		int	true	"Post ID"
+//	@Success		200		{object}	[]store.PostRevision
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/revisions [get]
+func (app *application) getPostRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	revisions, err := app.postRevisions(r)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, revisions); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+type PostRevisionDiff struct {
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Title   []diff.Change `json:"title"`
+	Content []diff.Change `json:"content"`
+}
+
+// GetPostRevisionDiff godoc
+//
+//	@Summary		Compares two versions of a post
+//	@Description	Returns a word diff of the title and content between two versions. Only the author and holders of post:update:any can see it
+//	@Tags			posts
+//	@Produce		json
+//	@Param			postID	path		int	true	"Post ID"
+//	@Param			from	query		int	true	"Version to compare from"
+//	@Param			to		query		int	true	"Version to compare to"
+//	@Success		200		{object}	PostRevisionDiff
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/revisions/diff [get]
+func (app *application) getPostRevisionDiffHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	from, err := strconv.Atoi(qs.Get("from"))
+	if err != nil {
+		app.errorBadRequest(w, r, errors.New("from must be a version number"))
+		return
+	}
+
+	to, err := strconv.Atoi(qs.Get("to"))
+	if err != nil {
+		app.errorBadRequest(w, r, errors.New("to must be a version number"))
+		return
+	}
+
+	revisions, err := app.postRevisions(r)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	a, b := findRevision(revisions, from), findRevision(revisions, to)
+	if a == nil || b == nil {
+		app.errorNotFound(w, r, store.ErrNotFound)
+		return
+	}
+
+	d := PostRevisionDiff{
+		From:    from,
+		To:      to,
+		Title:   diff.Words(a.Title, b.Title),
+		Content: diff.Words(a.Content, b.Content),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, d); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// postRevisions returns the stored revisions of the post in the context
+// followed by its current version.
+func (app *application) postRevisions(r *http.Request) ([]store.PostRevision, error) {
+	post := getPostFromCtx(r)
+
+	revisions, err := app.store.Posts.GetRevisions(r.Context(), post.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := store.PostRevision{
+		Version:   post.Version,
+		Title:     post.Title,
+		Content:   post.Content,
+		CreatedAt: post.UpdatedAt,
+	}
+
+	return append(revisions, current), nil
+}
+
+func findRevision(revisions []store.PostRevision, version int) *store.PostRevision {
+	for i := range revisions {
+		if revisions[i].Version == version {
+			return &revisions[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+type schedulerConfig struct {
+	interval  time.Duration
+	batchSize int
+	// trendingInterval is how often the trending tags are recomputed
+	trendingInterval time.Duration
+	// purgeInterval is how often expired rows are deleted
+	purgeInterval time.Duration
+}
+
+// runPostScheduler publishes due scheduled posts every interval until ctx
+// is done. Every replica runs it, PublishDue keeps them from publishing the
+// same post twice.
+func (app *application) runPostScheduler(ctx context.Context) {
+	ticker := time.NewTicker(app.config.scheduler.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.publishDuePosts(ctx)
+		}
+	}
+}
+
+func (app *application) publishDuePosts(ctx context.Context) {
+	batchSize := app.config.scheduler.batchSize
+
+	// keep going while full batches come back, there may be more due
+	for {
+		ids, err := app.store.Posts.PublishDue(ctx, batchSize)
+		if err != nil {
+			app.logger.Errorw("error publishing scheduled posts", "error", err)
+			return
+		}
+
+		if len(ids) > 0 {
+			app.invalidatePosts(ctx, ids...)
+			app.logger.Infow("published scheduled posts", "count", len(ids))
+		}
+
+		if len(ids) < batchSize {
+			return
+		}
+	}
+}
+
+// runTrendingRefresher recomputes the trending tags every trendingInterval
+// until ctx is done. RefreshTrending lets one replica at a time do the work.
+func (app *application) runTrendingRefresher(ctx context.Context) {
+	ticker := time.NewTicker(app.config.scheduler.trendingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := app.store.Tags.RefreshTrending(ctx); err != nil {
+				app.logger.Errorw("error refreshing trending tags", "error", err)
+			}
+		}
+	}
+}
+
+// runPurger deletes expired rows every purgeInterval until ctx is done. The
+// deletes are idempotent, so every replica can run it.
+func (app *application) runPurger(ctx context.Context) {
+	ticker := time.NewTicker(app.config.scheduler.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.purgeExpired(ctx)
+		}
+	}
+}
+
+func (app *application) purgeExpired(ctx context.Context) {
+	purges := []struct {
+		name  string
+		purge func(context.Context) (int64, error)
+	}{
+		{"idempotency keys", app.store.Idempotency.PurgeExpired},
+		{"login throttles", app.purgeLoginThrottles},
+		{"oauth states", app.store.Identities.PurgeExpiredStates},
+	}
+
+	for _, p := range purges {
+		count, err := p.purge(ctx)
+		if err != nil {
+			app.logger.Errorw("error purging expired rows", "table", p.name, "error", err)
+			continue
+		}
+
+		if count > 0 {
+			app.logger.Infow("purged expired rows", "table", p.name, "count", count)
+		}
+	}
+}
+
+// purgeLoginThrottles keeps throttles for the longer of the two windows, a
+// failure inside it still counts.
+func (app *application) purgeLoginThrottles(ctx context.Context) (int64, error) {
+	login := app.config.auth.login
+	return app.store.Logins.PurgeExpired(ctx, max(login.email.Window, login.ip.Window))
+}
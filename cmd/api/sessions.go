@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type sessionKey string
+
+const sessionKeyCtx sessionKey = "session"
+
+type mfaKey string
+
+// mfaKeyCtx is whether the login of the request passed two-factor
+// authentication
+const mfaKeyCtx mfaKey = "mfa"
+
+// sessionTouchInterval is how stale last_seen_at may get, so an active
+// session does not write on every request.
+const sessionTouchInterval = time.Minute
+
+type SessionWithCurrent struct {
+	store.Session
+	// Current is the session of the request
+	Current bool `json:"current"`
+}
+
+// startSession records a login on the device of the request and returns
+// the token of the new session.
+func (app *application) startSession(ctx context.Context, r *http.Request, user *store.User, mfa bool) (string, error) {
+	session := &store.Session{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+		ExpiresAt: time.Now().Add(app.config.auth.token.exp),
+	}
+
+	if err := app.store.Sessions.Create(ctx, session); err != nil {
+		return "", err
+	}
+
+	return app.generateAccessToken(user.ID, session.ID, mfa)
+}
+
+// getSession reads a session through the cache, like getUser.
+func (app *application) getSession(ctx context.Context, id string) (*store.Session, error) {
+	if !app.config.cache.Enabled {
+		return app.store.Sessions.GetByID(ctx, id)
+	}
+
+	return app.cacheStorage.Sessions.Get(ctx, id)
+}
+
+// touchSession saves the last use of the session in the background.
+func (app *application) touchSession(session store.Session) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), store.QueryTimeoutDuration)
+		defer cancel()
+
+		// a session revoked since it was read is not touched back to life
+		if _, err := app.store.Sessions.Touch(ctx, session.ID); err != nil && err != store.ErrNotFound {
+			app.logger.Errorw("error recording session use", "session_id", session.ID, "error", err)
+			return
+		}
+
+		// or every request until the entry expires would touch it again.
+		// Writing our copy back could undo a revoke that raced the touch,
+		// so it is dropped and read again instead.
+		app.invalidateSessions(ctx, session.ID)
+	}()
+}
+
+// invalidateSessions drops the cached copies of revoked sessions.
+func (app *application) invalidateSessions(ctx context.Context, ids ...string) {
+	if !app.config.cache.Enabled {
+		return
+	}
+
+	if err := app.cacheStorage.Sessions.Delete(ctx, ids...); err != nil {
+		app.logger.Errorw("error invalidating cached sessions", "session_ids", ids, "error", err)
+	}
+}
+
+func getSessionFromContext(r *http.Request) *store.Session {
+	session, _ := r.Context().Value(sessionKeyCtx).(*store.Session)
+	return session
+}
+
+func getMFAFromContext(r *http.Request) bool {
+	mfa, _ := r.Context().Value(mfaKeyCtx).(bool)
+	return mfa
+}
+
+// ListSessions godoc
+//
+//	@Summary		Lists the user's sessions
+//	@Description	Lists the devices the authenticated user is logged in on
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	[]SessionWithCurrent
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions [get]
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	current := getSessionFromContext(r)
+
+	sessions, err := app.store.Sessions.GetActiveByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	resp := make([]SessionWithCurrent, len(sessions))
+	for i, s := range sessions {
+		resp[i] = SessionWithCurrent{
+			Session: s,
+			Current: current != nil && s.ID == current.ID,
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// RevokeSession godoc
+//
+//	@Summary		Revokes a session
+//	@Description	Logs the authenticated user out of one session, the current one included
+//	@Tags			users
+//	@Produce		json
+//	@Param			sessionID	path		string	true	"Session ID"
+//	@Success		204			{string}	string	"Session revoked"
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions/{sessionID} [delete]
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		app.errorNotFound(w, r, store.ErrNotFound)
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	if err := app.store.Sessions.Revoke(ctx, user.ID, id.String()); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	app.invalidateSessions(ctx, id.String())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions godoc
+//
+//	@Summary		Revokes the other sessions
+//	@Description	Logs the authenticated user out everywhere but the current session
+//	@Tags			users
+//	@Produce		json
+//	@Success		204	{string}	string	"Sessions revoked"
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions/revoke-others [put]
+func (app *application) revokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	current := getSessionFromContext(r)
+	ctx := r.Context()
+
+	ids, err := app.store.Sessions.RevokeOthers(ctx, user.ID, current.ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	app.invalidateSessions(ctx, ids...)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type TagPostsPage struct {
+	Posts      []store.PostWithMetadata `json:"posts"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+// GetTagPosts godoc
+//
+//	@Summary		Fetches posts with a tag
+//	@Description	Fetches published posts with a tag, newest first. Pass next_cursor as after to get the next page.
+//	@Tags			tags
+//	@Produce		json
+//	@Param			tag		path		string	true	"Tag"
+//	@Param			after	query		string	false	"Cursor from the previous page"
+//	@Param			limit	query		int		false	"Limit"
+//	@Success		200		{object}	TagPostsPage
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tags/{tag}/posts [get]
+func (app *application) getTagPostsHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := store.NormalizeTag(chi.URLParam(r, "tag"))
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	cq := store.PostCursorQuery{
+		Limit: 20,
+	}
+
+	cq, err = cq.Parse(r)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(cq); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	posts, err := app.store.Tags.GetPosts(ctx, tag, user.ID, cq)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	page := make([]*store.Post, len(posts))
+	for i := range posts {
+		page[i] = &posts[i].Post
+
+		if err := app.attachQuotedPost(ctx, page[i], user); err != nil {
+			app.errorInternalServer(w, r, err)
+			return
+		}
+	}
+
+	if err := app.attachPolls(ctx, page, user.ID); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	resp := TagPostsPage{Posts: posts}
+	if len(posts) == cq.Limit {
+		resp.NextCursor = store.PostCursor(&posts[len(posts)-1].Post)
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// GetTrendingTags godoc
+//
+//	@Summary		Fetches trending tags
+//	@Description	Fetches the most used tags of the last hour, day or week
+//	@Tags			tags
+//	@Produce		json
+//	@Param			window	query		string	false	"hour, day or week"
+//	@Param			limit	query		int		false	"Limit"
+//	@Success		200		{object}	[]store.TrendingTag
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tags/trending [get]
+func (app *application) getTrendingTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tq := store.TrendingQuery{
+		Window: "day",
+		Limit:  10,
+	}
+
+	tq, err := tq.Parse(r)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(tq); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	tags, err := app.store.Tags.Trending(r.Context(), tq)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tags); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// GetFollowedTags godoc
+//
+//	@Summary		Fetches followed tags
+//	@Description	Fetches the tags the authenticated user follows
+//	@Tags			tags
+//	@Produce		json
+//	@Success		200	{object}	[]string
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tags/following [get]
+func (app *application) getFollowedTagsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	tags, err := app.store.Tags.GetFollowed(r.Context(), user.ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tags); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// FollowTag godoc
+//
+//	@Summary		Follows a tag
+//	@Description	Follows a tag, its posts show up in the authenticated user's feed
+//	@Tags			tags
+//	@Produce		json
+//	@Param			tag	path		string	true	"Tag"
+//	@Success		204	{string}	string	"Tag followed"
+//	@Failure		400	{object}	error
+//	@Failure		409	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tags/{tag}/follow [put]
+func (app *application) followTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := store.NormalizeTag(chi.URLParam(r, "tag"))
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if err := app.store.Tags.Follow(r.Context(), user.ID, tag); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.errorConflict(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnfollowTag godoc
+//
+//	@Summary		Unfollows a tag
+//	@Description	Unfollows a tag
+//	@Tags			tags
+//	@Produce		json
+//	@Param			tag	path		string	true	"Tag"
+//	@Success		204	{string}	string	"Tag unfollowed"
+//	@Failure		400	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/tags/{tag}/unfollow [put]
+func (app *application) unfollowTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := store.NormalizeTag(chi.URLParam(r, "tag"))
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if err := app.store.Tags.Unfollow(r.Context(), user.ID, tag); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
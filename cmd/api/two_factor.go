@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/codepnw/social/internal/totp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const totpIssuer = "GoSocial"
+
+type TwoFactorChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorRecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	// Token replaces the token of the session, it passed two-factor
+	// authentication
+	Token string `json:"token,omitempty"`
+}
+
+type VerifyTwoFactorPayload struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	// Code is a TOTP code or a recovery code
+	Code string `json:"code" validate:"required,max=20"`
+}
+
+type EnableTwoFactorPayload struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type TwoFactorPasswordPayload struct {
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+// twoFactorChallenge answers a correct password of a user with two-factor
+// authentication: the login finishes at /auth/token/2fa.
+func (app *application) twoFactorChallenge(w http.ResponseWriter, r *http.Request, user *store.User) {
+	token, err := app.generateMFAToken(user.ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	challenge := TwoFactorChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, challenge); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// VerifyTwoFactor godoc
+//
+//	@Summary		Finishes a two-factor login
+//	@Description	Exchanges the token of a two-factor challenge and a TOTP or recovery code for a token
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		VerifyTwoFactorPayload	true	"Challenge token and code"
+//	@Success		201		{string}	string					"Token"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		429		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/auth/token/2fa [post]
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyTwoFactorPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	jwtToken, err := app.authenticator.ValidateToken(payload.MFAToken)
+	if err != nil {
+		app.errorUnauthorized(w, r, err)
+		return
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+	if typ, _ := claims["typ"].(string); typ != tokenTypeMFA {
+		app.errorUnauthorized(w, r, fmt.Errorf("not a two-factor challenge token"))
+		return
+	}
+
+	userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+	if err != nil {
+		app.errorUnauthorized(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	// codes are throttled per account like passwords, six digits are quick
+	// to guess otherwise
+	key := fmt.Sprintf("2fa:%d", userID)
+	policy := app.config.auth.login.email
+
+	throttle, err := app.store.Logins.GetThrottle(ctx, key)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if wait := throttle.Wait(policy, time.Now()); wait > 0 {
+		app.errorLoginThrottled(w, r, wait)
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorUnauthorized(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	err = app.checkTwoFactorCode(r, userID, payload.Code)
+	if err == store.ErrNotFound {
+		if _, err := app.store.Logins.Fail(ctx, key, policy); err != nil {
+			app.errorInternalServer(w, r, err)
+			return
+		}
+
+		app.errorUnauthorized(w, r, fmt.Errorf("invalid two-factor code"))
+		return
+	}
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	app.resetLoginFailures(ctx, key)
+	app.recordLogin(ctx, r, user)
+
+	token, err := app.startSession(ctx, r, user, true)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, token); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// checkTwoFactorCode spends a TOTP or recovery code of the user. Codes that
+// are wrong, replayed or already used fail with ErrNotFound.
+func (app *application) checkTwoFactorCode(r *http.Request, userID int64, code string) error {
+	ctx := r.Context()
+
+	if store.IsRecoveryCode(code) {
+		return app.store.TwoFactor.UseRecoveryCode(ctx, userID, code)
+	}
+
+	tf, err := app.store.TwoFactor.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !tf.Enabled {
+		return store.ErrNotFound
+	}
+
+	step, ok := totp.Validate(tf.Secret, code, time.Now())
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	return app.store.TwoFactor.UseStep(ctx, userID, step)
+}
+
+// EnrollTwoFactor godoc
+//
+//	@Summary		Starts two-factor enrolment
+//	@Description	Creates a TOTP secret for the authenticated user, confirmed by enabling two-factor authentication
+//	@Tags			users
+//	@Produce		json
+//	@Success		201	{object}	TwoFactorEnrollment
+//	@Failure		409	{object}	error	"Already enabled"
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/enroll [post]
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.Enroll(r.Context(), user.ID, secret); err != nil {
+		switch err {
+		case store.ErrTwoFactorEnabled:
+			app.errorConflict(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	enrollment := TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, enrollment); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// EnableTwoFactor godoc
+//
+//	@Summary		Enables two-factor authentication
+//	@Description	Confirms the enrolled secret with a code and returns the recovery codes, shown only this once
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		EnableTwoFactorPayload	true	"TOTP code"
+//	@Success		200		{object}	TwoFactorRecoveryCodes
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error	"Not enrolled"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/enable [post]
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload EnableTwoFactorPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	tf, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	if tf.Enabled {
+		app.errorConflict(w, r, store.ErrTwoFactorEnabled)
+		return
+	}
+
+	step, ok := totp.Validate(tf.Secret, payload.Code, time.Now())
+	if !ok {
+		app.errorBadRequest(w, r, fmt.Errorf("invalid two-factor code"))
+		return
+	}
+
+	codes, err := app.store.TwoFactor.Enable(ctx, user.ID, step)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorConflict(w, r, store.ErrTwoFactorEnabled)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	// the session stays, only its token now says it passed 2FA
+	session := getSessionFromContext(r)
+
+	token, err := app.generateAccessToken(user.ID, session.ID, true)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	resp := TwoFactorRecoveryCodes{
+		RecoveryCodes: codes,
+		Token:         token,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// DisableTwoFactor godoc
+//
+//	@Summary		Disables two-factor authentication
+//	@Description	Disables two-factor authentication of the authenticated user, confirmed with the password
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TwoFactorPasswordPayload	true	"Password"
+//	@Success		204		{string}	string						"Two-factor authentication disabled"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error	"Required by the role"
+//	@Failure		404		{object}	error	"Not enabled"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/disable [post]
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.confirmPassword(w, r)
+	if !ok {
+		return
+	}
+
+	if user.Role.Require2FA {
+		app.errorForbidden(w, r)
+		return
+	}
+
+	if err := app.store.TwoFactor.Disable(r.Context(), user.ID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes godoc
+//
+//	@Summary		Regenerates the recovery codes
+//	@Description	Replaces the recovery codes of the authenticated user, confirmed with the password
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TwoFactorPasswordPayload	true	"Password"
+//	@Success		200		{object}	TwoFactorRecoveryCodes
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error	"Not enabled"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/recovery-codes [post]
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.confirmPassword(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+
+	tf, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil && err != store.ErrNotFound {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if tf == nil || !tf.Enabled {
+		app.errorNotFound(w, r, store.ErrNotFound)
+		return
+	}
+
+	codes, err := app.store.TwoFactor.RegenerateRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, TwoFactorRecoveryCodes{RecoveryCodes: codes}); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// confirmPassword reads a TwoFactorPasswordPayload and checks it against
+// the password of the authenticated user, writing the error response when
+// it does not match.
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	var payload TwoFactorPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return nil, false
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return nil, false
+	}
+
+	// the cached user has no password hash
+	user, err := app.store.Users.GetByID(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return nil, false
+	}
+
+	if err := user.Password.Compare(payload.Password); err != nil {
+		app.errorUnauthorized(w, r, errInvalidCredentials)
+		return nil, false
+	}
+
+	return user, true
+}
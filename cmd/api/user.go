@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -34,7 +35,9 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := app.getUser(r.Context(), userID)
+	ctx := r.Context()
+
+	user, err := app.getUser(ctx, userID)
 	if err != nil {
 		switch err {
 		case store.ErrNotFound:
@@ -46,6 +49,19 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		}					
 	}
 
+	// users who blocked the viewer are hidden from them
+	viewer := getUserFromContext(r)
+	blocked, err := app.store.Blocks.IsBlocked(ctx, user.ID, viewer.ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if blocked {
+		app.errorNotFound(w, r, store.ErrNotFound)
+		return
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
 		app.errorInternalServer(w, r, err)
 	}
@@ -54,14 +70,15 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 // FollowUser godoc
 //
 //	@Summary		Follows a user
-//	@Description	Follows a user by ID
+//	@Description	Follows a user by ID. Following a private user sends a follow request instead.
 //	@Tags			users
 //	@Accept			json
 //	@Produce		json
-//	@Param			userID	path		int		true	"User ID"
-//	@Success		204		{string}	string	"User followed"
-//	@Failure		400		{object}	error	"User payload missing"
-//	@Failure		404		{object}	error	"User not found"
+//	@Param			userID	path		int					true	"User ID"
+//	@Success		202		{object}	store.FollowRequest	"Follow requested"
+//	@Success		204		{string}	string				"User followed"
+//	@Failure		400		{object}	error				"User payload missing"
+//	@Failure		404		{object}	error				"User not found"
 //	@Security		ApiKeyAuth
 //	@Router			/users/{userID}/follow [put]
 func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -75,11 +92,30 @@ func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request
 
 	ctx := r.Context()
 
+	followedUser, err := app.store.Users.GetByID(ctx, followedID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	if followedUser.IsPrivate {
+		app.requestFollow(w, r, followerUser, followedUser)
+		return
+	}
+
 	if err := app.store.Followers.Follow(ctx, followerUser.ID, followedID); err != nil {
 		switch err {
 		case store.ErrConflict:
 			app.errorConflict(w, r, err)
 			return
+		case store.ErrBlocked:
+			app.errorForbidden(w, r)
+			return
 		default:
 			app.errorInternalServer(w, r, err)
 			return
@@ -91,6 +127,121 @@ func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+func (app *application) requestFollow(w http.ResponseWriter, r *http.Request, requester, user *store.User) {
+	if err := app.store.Followers.Request(r.Context(), requester.ID, user.ID); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.errorConflict(w, r, err)
+		case store.ErrBlocked:
+			app.errorForbidden(w, r)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	followRequest := store.FollowRequest{
+		UserID:      user.ID,
+		RequesterID: requester.ID,
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, followRequest); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// GetFollowRequests godoc
+//
+//	@Summary		Lists follow requests
+//	@Description	Lists the pending follow requests sent to the authenticated user
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	[]store.FollowRequest
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/follow-requests [get]
+func (app *application) getFollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	requests, err := app.store.Followers.GetRequests(r.Context(), user.ID)
+	if err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, requests); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
+// ApproveFollowRequest godoc
+//
+//	@Summary		Approves a follow request
+//	@Description	Approves a pending follow request by requester ID
+//	@Tags			users
+//	@Produce		json
+//	@Param			requesterID	path		int		true	"Requester ID"
+//	@Success		204			{string}	string	"Follow request approved"
+//	@Failure		400			{object}	error
+//	@Failure		404			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/follow-requests/{requesterID}/approve [put]
+func (app *application) approveFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	requesterID, err := strconv.ParseInt(chi.URLParam(r, "requesterID"), 10, 64)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := app.store.Followers.ApproveRequest(r.Context(), user.ID, requesterID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RejectFollowRequest godoc
+//
+//	@Summary		Rejects a follow request
+//	@Description	Rejects a pending follow request by requester ID
+//	@Tags			users
+//	@Produce		json
+//	@Param			requesterID	path		int		true	"Requester ID"
+//	@Success		204			{string}	string	"Follow request rejected"
+//	@Failure		400			{object}	error
+//	@Failure		404			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/follow-requests/{requesterID}/reject [put]
+func (app *application) rejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	requesterID, err := strconv.ParseInt(chi.URLParam(r, "requesterID"), 10, 64)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := app.store.Followers.RejectRequest(r.Context(), user.ID, requesterID); err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.errorNotFound(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // UnfollowUser gdoc
 //
 //	@Summary		Unfollow a user
@@ -125,6 +276,144 @@ func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// BlockUser godoc
+//
+//	@Summary		Blocks a user
+//	@Description	Blocks a user by ID and removes follows in both directions
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int		true	"User ID"
+//	@Success		204		{string}	string	"User blocked"
+//	@Failure		400		{object}	error	"User payload missing"
+//	@Failure		409		{object}	error	"User already blocked"
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/block [put]
+func (app *application) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	blocker := getUserFromContext(r)
+
+	blockedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if blockedID == blocker.ID {
+		app.errorBadRequest(w, r, errors.New("cannot block yourself"))
+		return
+	}
+
+	if err := app.store.Blocks.Block(r.Context(), blocker.ID, blockedID); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.errorConflict(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnblockUser godoc
+//
+//	@Summary		Unblocks a user
+//	@Description	Unblocks a user by ID
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int		true	"User ID"
+//	@Success		204		{string}	string	"User unblocked"
+//	@Failure		400		{object}	error	"User payload missing"
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/unblock [put]
+func (app *application) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	blocker := getUserFromContext(r)
+
+	blockedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := app.store.Blocks.Unblock(r.Context(), blocker.ID, blockedID); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MuteUser godoc
+//
+//	@Summary		Mutes a user
+//	@Description	Hides a user's posts and comments from the authenticated user
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int		true	"User ID"
+//	@Success		204		{string}	string	"User muted"
+//	@Failure		400		{object}	error	"User payload missing"
+//	@Failure		409		{object}	error	"User already muted"
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/mute [put]
+func (app *application) muteUserHandler(w http.ResponseWriter, r *http.Request) {
+	muter := getUserFromContext(r)
+
+	mutedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if mutedID == muter.ID {
+		app.errorBadRequest(w, r, errors.New("cannot mute yourself"))
+		return
+	}
+
+	if err := app.store.Mutes.Mute(r.Context(), muter.ID, mutedID); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.errorConflict(w, r, err)
+		default:
+			app.errorInternalServer(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnmuteUser godoc
+//
+//	@Summary		Unmutes a user
+//	@Description	Unmutes a user by ID
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int		true	"User ID"
+//	@Success		204		{string}	string	"User unmuted"
+//	@Failure		400		{object}	error	"User payload missing"
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/unmute [put]
+func (app *application) unmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	muter := getUserFromContext(r)
+
+	mutedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := app.store.Mutes.Unmute(r.Context(), muter.ID, mutedID); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // ActivateUser godoc
 //
 //	@Summary		Activates/Register a user
@@ -139,8 +428,9 @@ func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Reque
 //	@Router			/users/activate/{token} [put]
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	token := chi.URLParam(r, "token")
+	ctx := r.Context()
 
-	err := app.store.Users.Activate(r.Context(), token)
+	userID, err := app.store.Users.Activate(ctx, token)
 	if err != nil {
 		switch err {
 		case store.ErrNotFound:
@@ -151,11 +441,68 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// it was cached as missing while inactive
+	app.invalidateUser(ctx, userID)
+
 	if err := app.jsonResponse(w, http.StatusNoContent, ""); err != nil {
 		app.errorInternalServer(w, r, err)
 	}
 }
 
+type UpdateUserSettingsPayload struct {
+	AcceptsMessages *bool `json:"accepts_messages"`
+	IsPrivate       *bool `json:"is_private"`
+}
+
+// UpdateUserSettings godoc
+//
+//	@Summary		Updates the user settings
+//	@Description	Updates the settings of the authenticated user
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		UpdateUserSettingsPayload	true	"Settings payload"
+//	@Success		200		{object}	store.User
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/settings [patch]
+func (app *application) updateUserSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateUserSettingsPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.errorBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if payload.AcceptsMessages != nil {
+		user.AcceptsMessages = *payload.AcceptsMessages
+	}
+
+	if payload.IsPrivate != nil {
+		user.IsPrivate = *payload.IsPrivate
+	}
+
+	ctx := r.Context()
+
+	if err := app.store.Users.UpdateSettings(ctx, user); err != nil {
+		app.errorInternalServer(w, r, err)
+		return
+	}
+
+	app.invalidateUser(ctx, user.ID)
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.errorInternalServer(w, r, err)
+	}
+}
+
 func (app *application) userContextMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS messages;
+
+DROP TABLE IF EXISTS conversations;
+
+ALTER TABLE users DROP COLUMN accepts_messages;
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS report_actions;
+
+DROP TABLE IF EXISTS reports;
+
+ALTER TABLE users DROP COLUMN is_suspended;
+
+ALTER TABLE comments DROP COLUMN is_hidden;
+
+ALTER TABLE posts DROP COLUMN is_hidden;
@@ -0,0 +1,48 @@
+ALTER TABLE posts
+ADD COLUMN is_hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE comments
+ADD COLUMN is_hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE users
+ADD COLUMN is_suspended BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS reports (
+    id BIGSERIAL PRIMARY KEY,
+    reporter_id BIGINT NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id BIGINT NOT NULL,
+    reason VARCHAR(30) NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    assignee_id BIGINT,
+    resolution VARCHAR(30),
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    CHECK (target_type IN ('post', 'comment', 'user')),
+    CHECK (reason IN ('spam', 'harassment', 'hate_speech', 'violence', 'nudity', 'misinformation', 'other')),
+    CHECK (status IN ('open', 'claimed', 'resolved', 'dismissed')),
+    FOREIGN KEY (reporter_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (assignee_id) REFERENCES users (id) ON DELETE SET NULL
+);
+
+-- a user can only have one pending report per target
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_pending_target ON reports (reporter_id, target_type, target_id)
+WHERE status IN ('open', 'claimed');
+
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status, created_at);
+
+CREATE TABLE IF NOT EXISTS report_actions (
+    id BIGSERIAL PRIMARY KEY,
+    report_id BIGINT NOT NULL,
+    actor_id BIGINT NOT NULL,
+    action VARCHAR(30) NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (report_id) REFERENCES reports (id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_actions_report_id ON report_actions (report_id);
@@ -0,0 +1,3 @@
+-- tokens issued before this time are rejected (force-logout)
+ALTER TABLE users
+ADD COLUMN tokens_valid_after TIMESTAMP(0) WITH TIME ZONE;
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id BIGINT NOT NULL,
+    permission_id BIGINT NOT NULL,
+
+    PRIMARY KEY (role_id, permission_id),
+    FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permissions (id) ON DELETE CASCADE
+);
+
+INSERT INTO permissions (name, description)
+VALUES
+    ('post:update:any', 'Update posts of other users'),
+    ('post:delete:any', 'Delete posts of other users'),
+    ('comment:delete:any', 'Delete comments of other users'),
+    ('report:moderate', 'Work the moderation queue'),
+    ('user:list', 'List and filter all users'),
+    ('user:role:assign', 'Change the role of a user'),
+    ('user:suspend', 'Suspend and unsuspend users'),
+    ('user:activate', 'Activate users without an invitation'),
+    ('user:logout', 'Revoke every token of a user'),
+    ('role:manage', 'Create, update and delete roles and their permissions');
+
+-- default grants keep the previous role levels working:
+-- moderators could update posts and work the queue, admins could do everything
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'moderator' AND p.name IN ('post:update:any', 'report:moderate');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL,
+    version INT NOT NULL,
+    title TEXT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+
+    UNIQUE (post_id, version),
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
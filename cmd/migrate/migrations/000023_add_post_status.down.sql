@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_posts_drafts;
+
+DROP INDEX IF EXISTS idx_posts_scheduled;
+
+ALTER TABLE posts
+DROP COLUMN published_at,
+DROP COLUMN publish_at,
+DROP COLUMN status;
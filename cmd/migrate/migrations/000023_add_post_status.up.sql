@@ -0,0 +1,13 @@
+ALTER TABLE posts
+ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published',
+ADD COLUMN publish_at TIMESTAMP(0) WITH TIME ZONE,
+ADD COLUMN published_at TIMESTAMP(0) WITH TIME ZONE,
+ADD CONSTRAINT posts_status_check CHECK (status IN ('draft', 'scheduled', 'published')),
+ADD CONSTRAINT posts_publish_at_check CHECK (status <> 'scheduled' OR publish_at IS NOT NULL);
+
+UPDATE posts SET published_at = created_at;
+
+-- the scheduler only ever looks at due scheduled posts
+CREATE INDEX IF NOT EXISTS idx_posts_scheduled ON posts (publish_at) WHERE status = 'scheduled';
+
+CREATE INDEX IF NOT EXISTS idx_posts_drafts ON posts (user_id, updated_at) WHERE status <> 'published';
@@ -0,0 +1,5 @@
+ALTER TABLE posts
+DROP COLUMN is_quote,
+DROP COLUMN quoted_post_id;
+
+DROP TABLE IF EXISTS reposts;
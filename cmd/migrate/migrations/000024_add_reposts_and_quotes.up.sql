@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS reposts (
+    user_id BIGINT NOT NULL,
+    post_id BIGINT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (user_id, post_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reposts_post_id ON reposts (post_id);
+
+-- a quote keeps is_quote when the original is deleted, so it can show a tombstone
+ALTER TABLE posts
+ADD COLUMN quoted_post_id BIGINT REFERENCES posts (id) ON DELETE SET NULL,
+ADD COLUMN is_quote BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_posts_quoted_post_id ON posts (quoted_post_id);
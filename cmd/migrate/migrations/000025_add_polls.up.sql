@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS polls (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL UNIQUE,
+    multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+    id BIGSERIAL PRIMARY KEY,
+    poll_id BIGINT NOT NULL,
+    position SMALLINT NOT NULL,
+    text VARCHAR(100) NOT NULL,
+
+    UNIQUE (poll_id, position),
+    UNIQUE (id, poll_id),
+    FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE
+);
+
+-- one ballot per user and poll, a ballot picks one or more options
+CREATE TABLE IF NOT EXISTS poll_ballots (
+    poll_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (poll_id, user_id),
+    FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS poll_ballot_options (
+    poll_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    option_id BIGINT NOT NULL,
+
+    PRIMARY KEY (poll_id, user_id, option_id),
+    FOREIGN KEY (poll_id, user_id) REFERENCES poll_ballots (poll_id, user_id) ON DELETE CASCADE,
+    -- the option must belong to the poll the ballot is for
+    FOREIGN KEY (option_id, poll_id) REFERENCES poll_options (id, poll_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_poll_ballot_options_option_id ON poll_ballot_options (option_id);
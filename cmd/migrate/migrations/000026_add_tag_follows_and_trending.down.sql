@@ -0,0 +1,3 @@
+DROP MATERIALIZED VIEW IF EXISTS trending_tags;
+
+DROP TABLE IF EXISTS tag_follows;
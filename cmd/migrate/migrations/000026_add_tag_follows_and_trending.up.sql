@@ -0,0 +1,34 @@
+-- bring existing tags in line with the normalized form new posts get
+UPDATE posts SET tags = ARRAY(
+    SELECT DISTINCT lower(btrim(t, '#'))
+    FROM unnest(tags) t
+    WHERE btrim(t, '#') <> ''
+)
+WHERE tags IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS tag_follows (
+    user_id BIGINT NOT NULL,
+    tag VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (user_id, tag),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_tag_follows_tag ON tag_follows (tag);
+
+-- tag usage over the last hour, day and week, refreshed by the API
+CREATE MATERIALIZED VIEW IF NOT EXISTS trending_tags AS
+SELECT
+    t.tag,
+    COUNT(*) FILTER (WHERE p.published_at > NOW() - INTERVAL '1 hour') AS hour_count,
+    COUNT(*) FILTER (WHERE p.published_at > NOW() - INTERVAL '1 day') AS day_count,
+    COUNT(*) AS week_count
+FROM posts p, unnest(p.tags) AS t(tag)
+WHERE p.status = 'published'
+    AND NOT p.is_hidden
+    AND p.published_at > NOW() - INTERVAL '7 days'
+GROUP BY t.tag;
+
+-- needed to refresh concurrently
+CREATE UNIQUE INDEX IF NOT EXISTS idx_trending_tags_tag ON trending_tags (tag);
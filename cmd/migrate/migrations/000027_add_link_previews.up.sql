@@ -0,0 +1,14 @@
+-- previews are cached per URL and shared by every post linking to it,
+-- failed fetches are kept too so they are not retried on every post
+CREATE TABLE IF NOT EXISTS link_previews (
+    url TEXT PRIMARY KEY,
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image_url TEXT NOT NULL DEFAULT '',
+    site_name TEXT NOT NULL DEFAULT '',
+    failed BOOLEAN NOT NULL DEFAULT FALSE,
+    fetched_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE posts
+ADD COLUMN IF NOT EXISTS link_url TEXT;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS login_events;
+
+DROP TABLE IF EXISTS login_unlock_tokens;
+
+DROP TABLE IF EXISTS login_throttles;
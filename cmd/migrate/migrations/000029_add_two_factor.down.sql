@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS user_recovery_codes;
+
+DROP TABLE IF EXISTS user_totp;
+
+ALTER TABLE roles
+DROP COLUMN IF EXISTS require_2fa;
@@ -0,0 +1,23 @@
+ALTER TABLE roles
+ADD COLUMN require_2fa BOOLEAN NOT NULL DEFAULT false;
+
+-- enabled is false while the user has not yet confirmed a code of the secret
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id BIGINT PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT false,
+    -- the last time step a code was accepted for, codes are single use
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    user_id BIGINT NOT NULL,
+    code_hash BYTEA NOT NULL,
+    used_at TIMESTAMP(0) WITH TIME ZONE,
+
+    PRIMARY KEY (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider VARCHAR(50) NOT NULL,
+    subject TEXT NOT NULL,
+    user_id BIGINT NOT NULL,
+    email CITEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (provider, subject),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+-- pending sign ins, the state sent to the provider and what the callback
+-- needs to finish the flow
+CREATE TABLE IF NOT EXISTS oauth_states (
+    state BYTEA PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    code_verifier TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE personal_access_tokens ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE;
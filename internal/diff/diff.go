@@ -0,0 +1,94 @@
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	OpEqual  = "equal"
+	OpInsert = "insert"
+	OpDelete = "delete"
+)
+
+type Change struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Words returns the changes that turn a into b, word by word. Whitespace is
+// kept as its own token so joining the equal and inserted text gives b back.
+func Words(a, b string) []Change {
+	at, bt := tokenize(a), tokenize(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of at[i:]
+	// and bt[j:]
+	lcs := make([][]int, len(at)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bt)+1)
+	}
+	for i := len(at) - 1; i >= 0; i-- {
+		for j := len(bt) - 1; j >= 0; j-- {
+			if at[i] == bt[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	changes := []Change{}
+	i, j := 0, 0
+	for i < len(at) && j < len(bt) {
+		switch {
+		case at[i] == bt[j]:
+			changes = appendChange(changes, OpEqual, at[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			changes = appendChange(changes, OpDelete, at[i])
+			i++
+		default:
+			changes = appendChange(changes, OpInsert, bt[j])
+			j++
+		}
+	}
+	for ; i < len(at); i++ {
+		changes = appendChange(changes, OpDelete, at[i])
+	}
+	for ; j < len(bt); j++ {
+		changes = appendChange(changes, OpInsert, bt[j])
+	}
+
+	return changes
+}
+
+// appendChange merges runs of the same op into a single change.
+func appendChange(changes []Change, op, text string) []Change {
+	if n := len(changes); n > 0 && changes[n-1].Op == op {
+		changes[n-1].Text += text
+		return changes
+	}
+
+	return append(changes, Change{Op: op, Text: text})
+}
+
+func tokenize(s string) []string {
+	var tokens []string
+	var b strings.Builder
+	space := false
+
+	for _, r := range s {
+		if b.Len() > 0 && unicode.IsSpace(r) != space {
+			tokens = append(tokens, b.String())
+			b.Reset()
+		}
+		space = unicode.IsSpace(r)
+		b.WriteRune(r)
+	}
+	if b.Len() > 0 {
+		tokens = append(tokens, b.String())
+	}
+
+	return tokens
+}
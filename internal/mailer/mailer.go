@@ -6,6 +6,7 @@ const (
 	FromName = "GoSocial"
 	maxRetires = 3
 	UserWelcomeTemplate = "user_invitation.templ"
+	AccountLockedTemplate = "account_locked.templ"
 )
 
 //go:embed "templates"
@@ -0,0 +1,267 @@
+// Package oauth signs users in with external identity providers through
+// the authorization code flow with PKCE. OpenID Connect providers are found
+// through discovery and identified by their ID token; plain OAuth2
+// providers, like GitHub, by their user info endpoint.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrUnknownProvider = errors.New("oauth: unknown provider")
+	ErrNoIdentity      = errors.New("oauth: provider returned no identity")
+)
+
+type ProviderConfig struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// Issuer enables OpenID Connect: endpoints are discovered from it and
+	// the identity is read from the verified ID token
+	Issuer string
+
+	// the endpoints of a plain OAuth2 provider
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	// EmailsURL lists the emails of the user, for providers whose user
+	// info does not say whether the email is verified
+	EmailsURL string
+}
+
+// Identity is the user as the provider knows them.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+type Provider struct {
+	config   ProviderConfig
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	client   *http.Client
+}
+
+// NewProvider sets up the provider, running discovery for OpenID Connect.
+func NewProvider(ctx context.Context, config ProviderConfig, timeout time.Duration) (*Provider, error) {
+	p := &Provider{
+		config: config,
+		client: &http.Client{Timeout: timeout},
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Scopes:       config.Scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  config.AuthURL,
+				TokenURL: config.TokenURL,
+			},
+		},
+	}
+
+	if config.Issuer != "" {
+		ctx, cancel := context.WithTimeout(oidc.ClientContext(ctx, p.client), timeout)
+		defer cancel()
+
+		provider, err := oidc.NewProvider(ctx, config.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("oauth: discovering %s: %w", config.Name, err)
+		}
+
+		p.oauth2.Endpoint = provider.Endpoint()
+		p.verifier = provider.Verifier(&oidc.Config{ClientID: config.ClientID})
+	}
+
+	return p, nil
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns where to send the user to sign in. The nonce is only
+// used by OpenID Connect.
+func (p *Provider) AuthCodeURL(state, verifier, nonce string) string {
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if p.verifier != nil {
+		opts = append(opts, oidc.Nonce(nonce))
+	}
+
+	return p.oauth2.AuthCodeURL(state, opts...)
+}
+
+// Exchange trades the authorization code for the identity of the user.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	if p.verifier != nil {
+		return p.identityFromIDToken(ctx, token, nonce)
+	}
+
+	return p.identityFromUserInfo(ctx, token)
+}
+
+func (p *Provider) identityFromIDToken(ctx context.Context, token *oauth2.Token, nonce string) (*Identity, error) {
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrNoIdentity
+	}
+
+	idToken, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, errors.New("oauth: id token nonce mismatch")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+		Name              string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Provider:      p.config.Name,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Username:      firstNonEmpty(claims.PreferredUsername, claims.Name),
+	}, nil
+}
+
+func (p *Provider) identityFromUserInfo(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	var info struct {
+		Sub               string          `json:"sub"`
+		ID                json.RawMessage `json:"id"`
+		Email             string          `json:"email"`
+		EmailVerified     bool            `json:"email_verified"`
+		PreferredUsername string          `json:"preferred_username"`
+		Login             string          `json:"login"`
+		Name              string          `json:"name"`
+	}
+	if err := p.getJSON(ctx, token, p.config.UserInfoURL, &info); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Provider:      p.config.Name,
+		Subject:       firstNonEmpty(info.Sub, rawID(info.ID)),
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+		Username:      firstNonEmpty(info.PreferredUsername, info.Login, info.Name),
+	}
+
+	if identity.Subject == "" {
+		return nil, ErrNoIdentity
+	}
+
+	if p.config.EmailsURL != "" {
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := p.getJSON(ctx, token, p.config.EmailsURL, &emails); err != nil {
+			return nil, err
+		}
+
+		for _, e := range emails {
+			if e.Primary {
+				identity.Email = e.Email
+				identity.EmailVerified = e.Verified
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, token *oauth2.Token, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	token.SetAuthHeader(req)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth: %s returned %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// rawID reads an id that may be a JSON number or string.
+func rawID(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+
+	var n int64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return strconv.FormatInt(n, 10)
+	}
+
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// RandomString returns a random URL safe string, for states and nonces.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GenerateVerifier returns a PKCE code verifier.
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
@@ -0,0 +1,353 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	testClientID = "client"
+	testCode     = "code"
+)
+
+// mockProvider is an OpenID Connect provider that signs in whoever the
+// test says, checking the code and its PKCE verifier.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	// claims go into the next ID token, on top of the standard ones
+	claims map[string]any
+	// challenge is what the client sent to the authorize endpoint
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != testCode || s256(r.FormValue("code_verifier")) != m.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+
+		writeJSON(w, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.idToken(t),
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func (m *mockProvider) idToken(t *testing.T) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: m.key, KeyID: "test"}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]any{
+		"iss": m.URL,
+		"aud": testClientID,
+		"sub": "subject",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return raw
+}
+
+// authorize checks the URL the user is sent to, and remembers its PKCE
+// challenge for the token endpoint.
+func (m *mockProvider) authorize(t *testing.T, authURL, state, nonce string) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(authURL, m.URL+"/authorize?") {
+		t.Errorf("AuthCodeURL() = %s, want the discovered endpoint", authURL)
+	}
+
+	q := u.Query()
+	for param, want := range map[string]string{
+		"client_id":             testClientID,
+		"response_type":         "code",
+		"state":                 state,
+		"nonce":                 nonce,
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(param); got != want {
+			t.Errorf("AuthCodeURL() %s = %q, want %q", param, got, want)
+		}
+	}
+
+	m.challenge = q.Get("code_challenge")
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func newTestProvider(t *testing.T, issuer string) *Provider {
+	t.Helper()
+
+	p, err := NewProvider(context.Background(), ProviderConfig{
+		Name:        "mock",
+		ClientID:    testClientID,
+		RedirectURL: "https://app.example.com/oauth/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+		Issuer:      issuer,
+	}, time.Second*2)
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	return p
+}
+
+func TestProviderExchange(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		// nonce is the one the sign in started with, the ID token carries
+		// "nonce" unless the claims say otherwise
+		nonce   string
+		want    Identity
+		wantErr string
+	}{
+		{
+			name:   "verified email",
+			claims: map[string]any{"email": "ada@example.com", "email_verified": true, "preferred_username": "ada"},
+			nonce:  "nonce",
+			want:   Identity{Provider: "mock", Subject: "subject", Email: "ada@example.com", EmailVerified: true, Username: "ada"},
+		},
+		{
+			name:   "unverified email",
+			claims: map[string]any{"email": "ada@example.com", "email_verified": false, "name": "Ada"},
+			nonce:  "nonce",
+			want:   Identity{Provider: "mock", Subject: "subject", Email: "ada@example.com", Username: "Ada"},
+		},
+		{
+			name:    "nonce mismatch",
+			claims:  map[string]any{"nonce": "another"},
+			nonce:   "nonce",
+			wantErr: "nonce mismatch",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.claims = map[string]any{"nonce": "nonce"}
+			for k, v := range tt.claims {
+				m.claims[k] = v
+			}
+
+			p := newTestProvider(t, m.URL)
+
+			verifier := GenerateVerifier()
+			m.authorize(t, p.AuthCodeURL("state", verifier, tt.nonce), "state", tt.nonce)
+
+			got, err := p.Exchange(context.Background(), testCode, verifier, tt.nonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Exchange() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+
+			if *got != tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderExchangeRejectsBadTokens(t *testing.T) {
+	m := newMockProvider(t)
+	m.claims = map[string]any{"nonce": "nonce"}
+
+	p := newTestProvider(t, m.URL)
+
+	verifier := GenerateVerifier()
+	m.authorize(t, p.AuthCodeURL("state", verifier, "nonce"), "state", "nonce")
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		if _, err := p.Exchange(context.Background(), testCode, GenerateVerifier(), "nonce"); err == nil {
+			t.Error("Exchange() with another verifier succeeded")
+		}
+	})
+
+	t.Run("another audience", func(t *testing.T) {
+		m.claims["aud"] = "another client"
+		defer delete(m.claims, "aud")
+
+		if _, err := p.Exchange(context.Background(), testCode, verifier, "nonce"); err == nil {
+			t.Error("Exchange() of an ID token for another client succeeded")
+		}
+	})
+
+	t.Run("signed by another key", func(t *testing.T) {
+		key := m.key
+		defer func() { m.key = key }()
+
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.key = other
+
+		if _, err := p.Exchange(context.Background(), testCode, verifier, "nonce"); err == nil {
+			t.Error("Exchange() of an ID token with a bad signature succeeded")
+		}
+	})
+}
+
+func TestNewProviderDiscoveryFails(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := NewProvider(context.Background(), ProviderConfig{Name: "mock", Issuer: srv.URL}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "discovering mock") {
+		t.Errorf("NewProvider() error = %v, want a discovery error", err)
+	}
+}
+
+func TestProviderUserInfo(t *testing.T) {
+	var challenge string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if s256(r.FormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]any{"access_token": "access", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("GET /user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id": 42, "login": "octocat", "email": "public@example.com"}`))
+	})
+	mux.HandleFunc("GET /user/emails", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"email": "public@example.com", "primary": false, "verified": true},
+			{"email": "primary@example.com", "primary": true, "verified": false}
+		]`))
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	p, err := NewProvider(context.Background(), ProviderConfig{
+		Name:        "github",
+		ClientID:    testClientID,
+		AuthURL:     srv.URL + "/authorize",
+		TokenURL:    srv.URL + "/token",
+		UserInfoURL: srv.URL + "/user",
+		EmailsURL:   srv.URL + "/user/emails",
+	}, time.Second*2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := GenerateVerifier()
+
+	u, err := url.Parse(p.AuthCodeURL("state", verifier, "nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Has("nonce") {
+		t.Error("AuthCodeURL() sent a nonce to a plain OAuth2 provider")
+	}
+	challenge = u.Query().Get("code_challenge")
+
+	got, err := p.Exchange(context.Background(), testCode, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	// the primary email wins, and it is not verified
+	want := Identity{Provider: "github", Subject: "42", Email: "primary@example.com", Username: "octocat"}
+	if *got != want {
+		t.Errorf("Exchange() = %+v, want %+v", *got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package ratelimiter
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// benchmarkLimiter runs Allow from all procs, on one key to measure lock
+// contention and on unique keys to measure map growth and allocations.
+func benchmarkLimiter(b *testing.B, l Limiter) {
+	b.Run("one key", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				l.Allow("client", 1_000_000)
+			}
+		})
+	})
+
+	b.Run("unique keys", func(b *testing.B) {
+		var n atomic.Int64
+
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				l.Allow(strconv.FormatInt(n.Add(1), 10), 100)
+			}
+		})
+	})
+}
+
+func BenchmarkFixedWindowRateLimiter(b *testing.B) {
+	l := NewFixedWindowLimiter(time.Minute)
+	defer l.Stop()
+
+	benchmarkLimiter(b, l)
+}
+
+func BenchmarkTokenBucketRateLimiter(b *testing.B) {
+	l := NewTokenBucketLimiter(time.Minute)
+	defer l.Stop()
+
+	benchmarkLimiter(b, l)
+}
+
+func BenchmarkSlidingWindowRateLimiter(b *testing.B) {
+	l := NewSlidingWindowLimiter(time.Minute)
+	defer l.Stop()
+
+	benchmarkLimiter(b, l)
+}
+
+func BenchmarkRedisFixedWindowRateLimiter(b *testing.B) {
+	mr := miniredis.RunT(b)
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	fallback := NewFixedWindowLimiter(time.Minute)
+	defer fallback.Stop()
+
+	benchmarkLimiter(b, NewRedisFixedWindowLimiter(rdb, time.Minute, true, fallback))
+}
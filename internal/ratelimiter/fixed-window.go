@@ -6,42 +6,63 @@ import (
 )
 
 type FixedWindowRateLimiter struct {
-	sync.RWMutex
-	clients map[string]int
-	limit int
-	window time.Duration
+	sync.Mutex
+	clients map[string]*fixedWindow
+	window  time.Duration
+	now     func() time.Time
+
+	janitor *janitor
+}
+
+type fixedWindow struct {
+	count int
+	start time.Time
 }
 
-func NewFixedWindowLimiter(limit int, window time.Duration) *FixedWindowRateLimiter {
-	return &FixedWindowRateLimiter{
-		clients: make(map[string]int),
-		limit: limit,
-		window: window,
+func NewFixedWindowLimiter(window time.Duration) *FixedWindowRateLimiter {
+	rl := &FixedWindowRateLimiter{
+		clients: make(map[string]*fixedWindow),
+		window:  window,
+		now:     time.Now,
 	}
+	rl.janitor = startJanitor(window, rl.sweep)
+
+	return rl
 }
 
-func (rl *FixedWindowRateLimiter) Allow(ip string) (bool, time.Duration) {
-	rl.RLock()
-	count, exists := rl.clients[ip]
-	rl.RUnlock()
+func (rl *FixedWindowRateLimiter) Allow(key string, limit int) Result {
+	now := rl.now()
 
-	if !exists || count < rl.limit {
-		rl.Lock()
-		if !exists {
-			go rl.resetCount(ip)
-		}
+	rl.Lock()
+	defer rl.Unlock()
+
+	w, exists := rl.clients[key]
+	if !exists || now.Sub(w.start) >= rl.window {
+		w = &fixedWindow{start: now}
+		rl.clients[key] = w
+	}
 
-		rl.clients[ip]++
-		rl.Unlock()
-		return true, 0
+	reset := rl.window - now.Sub(w.start)
+	if w.count >= limit {
+		return Result{Limit: limit, Reset: reset, RetryAfter: reset}
 	}
 
-	return false, rl.window
+	w.count++
+
+	return Result{Allowed: true, Limit: limit, Remaining: limit - w.count, Reset: reset}
 }
 
-func (rl *FixedWindowRateLimiter) resetCount(ip string) {
-	time.Sleep(rl.window)
+func (rl *FixedWindowRateLimiter) sweep(now time.Time) {
 	rl.Lock()
-	delete(rl.clients, ip)
-	rl.Unlock()
-}
\ No newline at end of file
+	defer rl.Unlock()
+
+	for key, w := range rl.clients {
+		if now.Sub(w.start) >= rl.window {
+			delete(rl.clients, key)
+		}
+	}
+}
+
+func (rl *FixedWindowRateLimiter) Stop() {
+	rl.janitor.Stop()
+}
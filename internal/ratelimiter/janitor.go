@@ -0,0 +1,37 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// janitor drops expired clients on a timer, one goroutine per limiter
+// instead of one per client.
+type janitor struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+func startJanitor(interval time.Duration, sweep func(now time.Time)) *janitor {
+	j := &janitor{stop: make(chan struct{})}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case now := <-ticker.C:
+				sweep(now)
+			}
+		}
+	}()
+
+	return j
+}
+
+func (j *janitor) Stop() {
+	j.once.Do(func() { close(j.stop) })
+}
@@ -0,0 +1,88 @@
+package ratelimiter
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stoppableLimiter interface {
+	Limiter
+	Stop()
+}
+
+// settledGoroutines counts the goroutines once goroutines left over by other
+// tests have exited.
+func settledGoroutines() int {
+	n := runtime.NumGoroutine()
+	for range 100 {
+		time.Sleep(time.Millisecond * 20)
+
+		m := runtime.NumGoroutine()
+		if m == n {
+			return n
+		}
+		n = m
+	}
+
+	return n
+}
+
+// waitForGoroutines polls until the number of goroutines is want, other
+// tests' goroutines may still be winding down.
+func waitForGoroutines(t *testing.T, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		got := runtime.NumGoroutine()
+		if got == want {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines, want %d", got, want)
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestLimitersStartOnlyTheJanitor(t *testing.T) {
+	limiters := map[string]func() stoppableLimiter{
+		AlgorithmFixedWindow:   func() stoppableLimiter { return NewFixedWindowLimiter(time.Millisecond * 10) },
+		AlgorithmTokenBucket:   func() stoppableLimiter { return NewTokenBucketLimiter(time.Millisecond * 10) },
+		AlgorithmSlidingWindow: func() stoppableLimiter { return NewSlidingWindowLimiter(time.Millisecond * 10) },
+	}
+
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			before := settledGoroutines()
+
+			l := newLimiter()
+
+			// many clients at once, while the janitor sweeps under them
+			var wg sync.WaitGroup
+			for i := range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := range 200 {
+						l.Allow(strconv.Itoa(i*1000+j), 5)
+					}
+				}()
+			}
+			wg.Wait()
+
+			waitForGoroutines(t, before+1)
+
+			l.Stop()
+			// a second Stop must not panic
+			l.Stop()
+
+			waitForGoroutines(t, before)
+		})
+	}
+}
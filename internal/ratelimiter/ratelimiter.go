@@ -1,13 +1,93 @@
 package ratelimiter
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 type Limiter interface {
-	Allow(ip string) (bool, time.Duration)
+	// Allow counts a request for key, which may make limit requests per
+	// window. The window belongs to the limiter, the limit to the caller.
+	Allow(key string, limit int) Result
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the client has its full limit again
+	Reset time.Duration
+	// RetryAfter is how long a rejected client should wait
+	RetryAfter time.Duration
+}
+
+const (
+	AlgorithmFixedWindow   = "fixed-window"
+	AlgorithmTokenBucket   = "token-bucket"
+	AlgorithmSlidingWindow = "sliding-window"
+)
+
+// Policy is the limit of a group of routes.
+type Policy struct {
+	Limit  int
+	Window time.Duration
 }
 
 type Config struct {
+	// RequestsPerTimeFrame and TimeFrame are the default policy, for
+	// routes without one of their own
 	RequestsPerTimeFrame int
 	TimeFrame            time.Duration
 	Enabled              bool
+	// Policies are the limits per route group, by name
+	Policies map[string]Policy
+	// RoleMultipliers scale the limits of authenticated users by role name,
+	// roles not listed get the plain limit
+	RoleMultipliers map[string]float64
+	// Algorithm picks the in-memory limiter, see New. The Redis limiter
+	// always counts fixed windows
+	Algorithm string
+	// Distributed shares the limit across replicas through Redis
+	Distributed bool
+	// FailOpen falls back to the in-memory limiter while Redis is
+	// unavailable, instead of rejecting requests
+	FailOpen bool
+}
+
+// Validate checks that every policy can let requests through. A zero limit
+// or window would reject everything, and the token bucket divides by both.
+func (c Config) Validate() error {
+	if c.RequestsPerTimeFrame <= 0 || c.TimeFrame <= 0 {
+		return errors.New("rate limiter: the default policy needs a positive limit and time frame")
+	}
+
+	for name, p := range c.Policies {
+		if p.Limit <= 0 || p.Window <= 0 {
+			return fmt.Errorf("rate limiter: policy %q needs a positive limit and window", name)
+		}
+	}
+
+	for role, m := range c.RoleMultipliers {
+		if m <= 0 {
+			return fmt.Errorf("rate limiter: multiplier of role %q must be positive", role)
+		}
+	}
+
+	return nil
+}
+
+// New returns the in-memory limiter for the configured algorithm, counting
+// over the given window.
+func New(algorithm string, window time.Duration) (Limiter, error) {
+	switch algorithm {
+	case AlgorithmFixedWindow:
+		return NewFixedWindowLimiter(window), nil
+	case AlgorithmTokenBucket:
+		return NewTokenBucketLimiter(window), nil
+	case AlgorithmSlidingWindow:
+		return NewSlidingWindowLimiter(window), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limiter algorithm %q", algorithm)
+	}
 }
@@ -0,0 +1,131 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is the time of a limiter under test, moved by hand.
+type fakeClock struct {
+	t time.Time
+}
+
+// newFakeClock starts on a minute boundary, so window indexes line up
+// with the steps of the tests.
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// step is one request of a test, made after moving the clock by advance.
+type step struct {
+	advance       time.Duration
+	wantAllowed   bool
+	wantRemaining int
+	// wantRetryAfter is only checked for rejected requests
+	wantRetryAfter time.Duration
+}
+
+func runSteps(t *testing.T, l Limiter, clock *fakeClock, limit int, steps []step) {
+	t.Helper()
+
+	for i, s := range steps {
+		clock.Advance(s.advance)
+
+		res := l.Allow("client", limit)
+		if res.Allowed != s.wantAllowed {
+			t.Fatalf("step %d: Allowed = %v, want %v", i, res.Allowed, s.wantAllowed)
+		}
+
+		if res.Limit != limit {
+			t.Errorf("step %d: Limit = %d, want %d", i, res.Limit, limit)
+		}
+
+		if res.Remaining != s.wantRemaining {
+			t.Errorf("step %d: Remaining = %d, want %d", i, res.Remaining, s.wantRemaining)
+		}
+
+		if !res.Allowed && !closeTo(res.RetryAfter, s.wantRetryAfter) {
+			t.Errorf("step %d: RetryAfter = %v, want %v", i, res.RetryAfter, s.wantRetryAfter)
+		}
+	}
+}
+
+// closeTo compares durations computed from floats.
+func closeTo(got, want time.Duration) bool {
+	diff := got - want
+	return diff > -time.Millisecond && diff < time.Millisecond
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() Config {
+		return Config{
+			RequestsPerTimeFrame: 20,
+			TimeFrame:            time.Second * 5,
+			Policies: map[string]Policy{
+				"login": {Limit: 10, Window: time.Minute},
+			},
+			RoleMultipliers: map[string]float64{"admin": 5},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{"valid", func(c *Config) {}, false},
+		{"zero default limit", func(c *Config) { c.RequestsPerTimeFrame = 0 }, true},
+		{"zero time frame", func(c *Config) { c.TimeFrame = 0 }, true},
+		{"zero policy limit", func(c *Config) { c.Policies["read"] = Policy{Limit: 0, Window: time.Minute} }, true},
+		{"negative policy limit", func(c *Config) { c.Policies["read"] = Policy{Limit: -1, Window: time.Minute} }, true},
+		{"zero policy window", func(c *Config) { c.Policies["read"] = Policy{Limit: 5} }, true},
+		{"zero multiplier", func(c *Config) { c.RoleMultipliers["guest"] = 0 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmFixedWindow, AlgorithmTokenBucket, AlgorithmSlidingWindow} {
+		l, err := New(algorithm, time.Minute)
+		if err != nil {
+			t.Errorf("New(%q) error = %v", algorithm, err)
+			continue
+		}
+		l.(interface{ Stop() }).Stop()
+	}
+
+	if _, err := New("leaky-bucket", time.Minute); err == nil {
+		t.Error("New() of an unknown algorithm succeeded")
+	}
+}
+
+func TestFixedWindowRateLimiter(t *testing.T) {
+	clock := newFakeClock()
+
+	l := NewFixedWindowLimiter(time.Minute)
+	defer l.Stop()
+	l.now = clock.Now
+
+	runSteps(t, l, clock, 3, []step{
+		{wantAllowed: true, wantRemaining: 2},
+		{advance: time.Second * 10, wantAllowed: true, wantRemaining: 1},
+		{wantAllowed: true, wantRemaining: 0},
+		{advance: time.Second * 20, wantAllowed: false, wantRemaining: 0, wantRetryAfter: time.Second * 30},
+		// the window started with the first request
+		{advance: time.Second * 30, wantAllowed: true, wantRemaining: 2},
+	})
+}
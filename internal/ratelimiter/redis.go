@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fixedWindowScript counts a request in the current window and returns the
+// count and the milliseconds left in the window. Running it as one script
+// keeps the increment and the expiry atomic across replicas.
+var fixedWindowScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+
+return {count, ttl}
+`)
+
+const (
+	redisTimeout = 50 * time.Millisecond
+	// redisCooldown is how long the limiter stops asking Redis after an
+	// error, so an outage does not add a timeout to every request
+	redisCooldown = 5 * time.Second
+)
+
+// RedisFixedWindowRateLimiter keeps the fixed window counts in Redis, so
+// all replicas share one limit. While Redis is unavailable it falls back to
+// the in-memory limiter when FailOpen is set, and rejects requests
+// otherwise.
+type RedisFixedWindowRateLimiter struct {
+	rdb      *redis.Client
+	window   time.Duration
+	failOpen bool
+	fallback Limiter
+	now      func() time.Time
+
+	// unix nanos until which Redis is skipped
+	downUntil atomic.Int64
+}
+
+func NewRedisFixedWindowLimiter(rdb *redis.Client, window time.Duration, failOpen bool, fallback Limiter) *RedisFixedWindowRateLimiter {
+	return &RedisFixedWindowRateLimiter{
+		rdb:      rdb,
+		window:   window,
+		failOpen: failOpen,
+		fallback: fallback,
+		now:      time.Now,
+	}
+}
+
+func (rl *RedisFixedWindowRateLimiter) Allow(key string, limit int) Result {
+	if rl.now().UnixNano() < rl.downUntil.Load() {
+		return rl.unavailable(key, limit)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	res, err := fixedWindowScript.Run(ctx, rl.rdb, []string{"ratelimit:" + key}, rl.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		rl.downUntil.Store(rl.now().Add(redisCooldown).UnixNano())
+		return rl.unavailable(key, limit)
+	}
+
+	count, reset := int(res[0]), time.Duration(res[1])*time.Millisecond
+	if count <= limit {
+		return Result{Allowed: true, Limit: limit, Remaining: limit - count, Reset: reset}
+	}
+
+	return Result{Limit: limit, Reset: reset, RetryAfter: reset}
+}
+
+func (rl *RedisFixedWindowRateLimiter) unavailable(key string, limit int) Result {
+	if rl.failOpen {
+		return rl.fallback.Allow(key, limit)
+	}
+
+	return Result{Limit: limit, Reset: rl.window, RetryAfter: rl.window}
+}
@@ -0,0 +1,211 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// countingLimiter stands in for the in-memory fallback.
+type countingLimiter struct {
+	calls int
+}
+
+func (l *countingLimiter) Allow(key string, limit int) Result {
+	l.calls++
+	return Result{Allowed: true, Limit: limit, Remaining: limit - 1}
+}
+
+func newTestRedisLimiter(t *testing.T, mr *miniredis.Miniredis, failOpen bool) (*RedisFixedWindowRateLimiter, *countingLimiter, *fakeClock) {
+	t.Helper()
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	clock := newFakeClock()
+	fallback := &countingLimiter{}
+
+	l := NewRedisFixedWindowLimiter(rdb, time.Minute, failOpen, fallback)
+	l.now = clock.Now
+
+	return l, fallback, clock
+}
+
+func TestRedisFixedWindowRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		// before runs against Redis ahead of the requests
+		before        func(mr *miniredis.Miniredis)
+		requests      int
+		wantAllowed   int
+		wantRemaining int
+	}{
+		{name: "under the limit", limit: 5, requests: 3, wantAllowed: 3, wantRemaining: 2},
+		{name: "at the limit", limit: 3, requests: 3, wantAllowed: 3, wantRemaining: 0},
+		{name: "over the limit", limit: 3, requests: 5, wantAllowed: 3, wantRemaining: 0},
+		{
+			name:  "counts left by another replica",
+			limit: 5,
+			before: func(mr *miniredis.Miniredis) {
+				mr.Set("ratelimit:client", "4")
+				mr.SetTTL("ratelimit:client", time.Second*30)
+			},
+			requests:    2,
+			wantAllowed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			l, fallback, _ := newTestRedisLimiter(t, mr, true)
+
+			if tt.before != nil {
+				tt.before(mr)
+			}
+
+			var allowed int
+			var last Result
+			for range tt.requests {
+				last = l.Allow("client", tt.limit)
+				if last.Allowed {
+					allowed++
+				}
+			}
+
+			if allowed != tt.wantAllowed {
+				t.Errorf("allowed %d requests, want %d", allowed, tt.wantAllowed)
+			}
+
+			if last.Remaining != tt.wantRemaining {
+				t.Errorf("Remaining = %d, want %d", last.Remaining, tt.wantRemaining)
+			}
+
+			if !last.Allowed && (last.RetryAfter <= 0 || last.RetryAfter > time.Minute) {
+				t.Errorf("RetryAfter = %v, want the rest of the window", last.RetryAfter)
+			}
+
+			if fallback.calls != 0 {
+				t.Errorf("fallback called %d times with Redis up", fallback.calls)
+			}
+		})
+	}
+}
+
+func TestRedisFixedWindowScript(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l, _, _ := newTestRedisLimiter(t, mr, true)
+
+	l.Allow("client", 10)
+
+	// the first request of a window sets its expiry
+	if ttl := mr.TTL("ratelimit:client"); ttl != time.Minute {
+		t.Errorf("TTL = %v, want %v", ttl, time.Minute)
+	}
+
+	mr.FastForward(time.Second * 45)
+
+	res := l.Allow("client", 10)
+	if got, _ := mr.Get("ratelimit:client"); got != "2" {
+		t.Errorf("count = %s, want 2", got)
+	}
+	// later requests keep the window's expiry
+	if !closeTo(res.Reset, time.Second*15) {
+		t.Errorf("Reset = %v, want %v", res.Reset, time.Second*15)
+	}
+
+	mr.FastForward(time.Second * 15)
+
+	if res := l.Allow("client", 10); res.Remaining != 9 {
+		t.Errorf("Remaining = %d after the window expired, want 9", res.Remaining)
+	}
+
+	// a counter that lost its expiry gets one again instead of living on
+	mr.Set("ratelimit:stuck", "3")
+	l.Allow("stuck", 10)
+
+	if ttl := mr.TTL("ratelimit:stuck"); ttl != time.Minute {
+		t.Errorf("TTL of a counter without expiry = %v, want %v", ttl, time.Minute)
+	}
+}
+
+func TestRedisFixedWindowSharedAcrossReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a, _, _ := newTestRedisLimiter(t, mr, true)
+	b, _, _ := newTestRedisLimiter(t, mr, true)
+
+	a.Allow("client", 2)
+	b.Allow("client", 2)
+
+	if res := a.Allow("client", 2); res.Allowed {
+		t.Error("replica allowed a request over the shared limit")
+	}
+}
+
+func TestRedisFixedWindowFallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		failOpen    bool
+		wantAllowed bool
+		wantCalls   int
+	}{
+		{name: "fail open", failOpen: true, wantAllowed: true, wantCalls: 1},
+		{name: "fail closed", failOpen: false, wantAllowed: false, wantCalls: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			l, fallback, _ := newTestRedisLimiter(t, mr, tt.failOpen)
+
+			mr.Close()
+
+			res := l.Allow("client", 5)
+			if res.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", res.Allowed, tt.wantAllowed)
+			}
+
+			if fallback.calls != tt.wantCalls {
+				t.Errorf("fallback called %d times, want %d", fallback.calls, tt.wantCalls)
+			}
+
+			if !tt.failOpen && res.RetryAfter != time.Minute {
+				t.Errorf("RetryAfter = %v, want the window", res.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestRedisFixedWindowCooldown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l, fallback, clock := newTestRedisLimiter(t, mr, true)
+
+	mr.Close()
+	l.Allow("client", 5)
+
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Redis is back, but the limiter does not ask until the cooldown ends
+	l.Allow("client", 5)
+	if fallback.calls != 2 {
+		t.Fatalf("fallback called %d times during the cooldown, want 2", fallback.calls)
+	}
+	if mr.Exists("ratelimit:client") {
+		t.Error("Redis was used during the cooldown")
+	}
+
+	clock.Advance(redisCooldown)
+
+	l.Allow("client", 5)
+	if fallback.calls != 2 {
+		t.Errorf("fallback called after the cooldown")
+	}
+	if got, _ := mr.Get("ratelimit:client"); got != "1" {
+		t.Errorf("count = %q after the cooldown, want 1", got)
+	}
+}
@@ -0,0 +1,118 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindowRateLimiter estimates the requests of the last window from
+// the counts of the current and the previous fixed window, weighting the
+// previous one by how much of it still overlaps. It smooths out the bursts
+// a fixed window allows at its boundaries.
+type SlidingWindowRateLimiter struct {
+	sync.Mutex
+	clients map[string]*slidingWindow
+	window  time.Duration
+	now     func() time.Time
+
+	janitor *janitor
+}
+
+type slidingWindow struct {
+	// index of the current fixed window since the epoch
+	index int64
+	curr  int
+	prev  int
+}
+
+func NewSlidingWindowLimiter(window time.Duration) *SlidingWindowRateLimiter {
+	rl := &SlidingWindowRateLimiter{
+		clients: make(map[string]*slidingWindow),
+		window:  window,
+		now:     time.Now,
+	}
+	rl.janitor = startJanitor(window, rl.sweep)
+
+	return rl
+}
+
+func (rl *SlidingWindowRateLimiter) Allow(key string, limit int) Result {
+	now := rl.now()
+	index := now.UnixNano() / int64(rl.window)
+	// how far into the current window we are, from 0 to 1
+	elapsed := float64(now.UnixNano()%int64(rl.window)) / float64(rl.window)
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	w, exists := rl.clients[key]
+	if !exists {
+		w = &slidingWindow{index: index}
+		rl.clients[key] = w
+	}
+
+	switch {
+	case w.index == index-1:
+		w.prev, w.curr = w.curr, 0
+	case w.index < index-1:
+		w.prev, w.curr = 0, 0
+	}
+	w.index = index
+
+	res := Result{Limit: limit}
+
+	estimate := float64(w.prev)*(1-elapsed) + float64(w.curr)
+	if estimate < float64(limit) {
+		w.curr++
+		estimate++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = rl.retryAfter(w, limit, elapsed)
+	}
+
+	res.Remaining = max(0, limit-int(estimate+0.5))
+	// by the end of the next window both counts have slid out
+	res.Reset = time.Duration((2 - elapsed) * float64(rl.window))
+	if w.curr == 0 {
+		res.Reset = time.Duration((1 - elapsed) * float64(rl.window))
+	}
+
+	return res
+}
+
+// retryAfter is how long until enough of the previous window has slid out,
+// or until the next window when the current one alone is full.
+func (rl *SlidingWindowRateLimiter) retryAfter(w *slidingWindow, limit int, elapsed float64) time.Duration {
+	untilNext := time.Duration((1 - elapsed) * float64(rl.window))
+
+	room := limit - w.curr
+	if room <= 0 || w.prev == 0 {
+		return untilNext
+	}
+
+	// the previous window weighs prev*(1-e), which has to drop below room
+	e := 1 - float64(room)/float64(w.prev)
+	if e <= elapsed {
+		return 0
+	}
+
+	return time.Duration((e - elapsed) * float64(rl.window))
+}
+
+// sweep drops clients with nothing in the current or previous window.
+func (rl *SlidingWindowRateLimiter) sweep(now time.Time) {
+	index := now.UnixNano() / int64(rl.window)
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	for key, w := range rl.clients {
+		if w.index < index-1 {
+			delete(rl.clients, key)
+		}
+	}
+}
+
+func (rl *SlidingWindowRateLimiter) Stop() {
+	rl.janitor.Stop()
+}
@@ -0,0 +1,100 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		steps []step
+	}{
+		{
+			name:  "full current window waits for the next",
+			limit: 3,
+			steps: []step{
+				{advance: time.Second * 30, wantAllowed: true, wantRemaining: 2},
+				{wantAllowed: true, wantRemaining: 1},
+				{wantAllowed: true, wantRemaining: 0},
+				{advance: time.Second * 10, wantAllowed: false, wantRemaining: 0, wantRetryAfter: time.Second * 20},
+			},
+		},
+		{
+			// 10 requests in the previous window still weigh 7.5 a quarter
+			// into the next, so only 3 more fit
+			name:  "previous window slides out",
+			limit: 10,
+			steps: append(repeat(10, step{wantAllowed: true}, time.Second*30),
+				// Remaining rounds the estimate of 8.5
+				step{advance: time.Second * 45, wantAllowed: true, wantRemaining: 1},
+				step{wantAllowed: true, wantRemaining: 0},
+				step{wantAllowed: true, wantRemaining: 0},
+				// the previous window has to weigh less than 7 at 0.3
+				step{wantAllowed: false, wantRemaining: 0, wantRetryAfter: time.Second * 3},
+				step{advance: time.Second*3 + time.Millisecond, wantAllowed: true, wantRemaining: 0},
+			),
+		},
+		{
+			name:  "two windows later nothing is left",
+			limit: 2,
+			steps: []step{
+				{wantAllowed: true, wantRemaining: 1},
+				{wantAllowed: true, wantRemaining: 0},
+				{advance: time.Minute * 2, wantAllowed: true, wantRemaining: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+
+			l := NewSlidingWindowLimiter(time.Minute)
+			defer l.Stop()
+			l.now = clock.Now
+
+			runSteps(t, l, clock, tt.limit, tt.steps)
+		})
+	}
+}
+
+// repeat returns n copies of s, the first one after advance. Remaining
+// counts down from n-1.
+func repeat(n int, s step, advance time.Duration) []step {
+	steps := make([]step, n)
+	for i := range steps {
+		steps[i] = s
+		steps[i].wantRemaining = n - 1 - i
+	}
+	steps[0].advance = advance
+
+	return steps
+}
+
+func TestSlidingWindowSweep(t *testing.T) {
+	clock := newFakeClock()
+
+	l := NewSlidingWindowLimiter(time.Minute)
+	defer l.Stop()
+	l.now = clock.Now
+
+	l.Allow("old", 5)
+	clock.Advance(time.Minute)
+	l.Allow("recent", 5)
+
+	// "old" is in the previous window now and still counts
+	l.sweep(clock.Now())
+	if _, ok := l.clients["old"]; !ok {
+		t.Error("sweep dropped a client of the previous window")
+	}
+
+	l.sweep(clock.Now().Add(time.Minute))
+	if _, ok := l.clients["old"]; ok {
+		t.Error("sweep kept a client with nothing in the last two windows")
+	}
+	if _, ok := l.clients["recent"]; !ok {
+		t.Error("sweep dropped a client of the previous window")
+	}
+}
@@ -0,0 +1,85 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucketRateLimiter gives every client a bucket of limit tokens that
+// refills evenly over the window, so bursts never exceed limit.
+type TokenBucketRateLimiter struct {
+	sync.Mutex
+	buckets map[string]*bucket
+	window  time.Duration
+	now     func() time.Time
+
+	janitor *janitor
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucketLimiter(window time.Duration) *TokenBucketRateLimiter {
+	rl := &TokenBucketRateLimiter{
+		buckets: make(map[string]*bucket),
+		window:  window,
+		now:     time.Now,
+	}
+	rl.janitor = startJanitor(window, rl.sweep)
+
+	return rl
+}
+
+func (rl *TokenBucketRateLimiter) Allow(key string, limit int) Result {
+	now := rl.now()
+	capacity := float64(limit)
+	// tokens per second
+	rate := capacity / rl.window.Seconds()
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	b, exists := rl.buckets[key]
+	if !exists {
+		b = &bucket{tokens: capacity, last: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens = min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	res := Result{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((capacity - b.tokens) / rate)
+
+	return res
+}
+
+// sweep drops buckets that have refilled, they are the same as new ones.
+func (rl *TokenBucketRateLimiter) sweep(now time.Time) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) >= rl.window {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+func (rl *TokenBucketRateLimiter) Stop() {
+	rl.janitor.Stop()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,90 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		steps []step
+	}{
+		{
+			// 4 per minute refill one token every 15 seconds
+			name:  "burst then refill",
+			limit: 4,
+			steps: []step{
+				{wantAllowed: true, wantRemaining: 3},
+				{wantAllowed: true, wantRemaining: 2},
+				{wantAllowed: true, wantRemaining: 1},
+				{wantAllowed: true, wantRemaining: 0},
+				{wantAllowed: false, wantRemaining: 0, wantRetryAfter: time.Second * 15},
+				{advance: time.Second * 5, wantAllowed: false, wantRemaining: 0, wantRetryAfter: time.Second * 10},
+				{advance: time.Second * 10, wantAllowed: true, wantRemaining: 0},
+				{advance: time.Second * 30, wantAllowed: true, wantRemaining: 1},
+			},
+		},
+		{
+			name:  "refill stops at the limit",
+			limit: 2,
+			steps: []step{
+				{wantAllowed: true, wantRemaining: 1},
+				{advance: time.Hour, wantAllowed: true, wantRemaining: 1},
+				{wantAllowed: true, wantRemaining: 0},
+				{wantAllowed: false, wantRemaining: 0, wantRetryAfter: time.Second * 30},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+
+			l := NewTokenBucketLimiter(time.Minute)
+			defer l.Stop()
+			l.now = clock.Now
+
+			runSteps(t, l, clock, tt.limit, tt.steps)
+		})
+	}
+}
+
+func TestTokenBucketReset(t *testing.T) {
+	clock := newFakeClock()
+
+	l := NewTokenBucketLimiter(time.Minute)
+	defer l.Stop()
+	l.now = clock.Now
+
+	for range 6 {
+		l.Allow("client", 6)
+	}
+
+	// an empty bucket takes the whole window to fill again
+	if res := l.Allow("client", 6); !closeTo(res.Reset, time.Minute) {
+		t.Errorf("Reset = %v, want %v", res.Reset, time.Minute)
+	}
+}
+
+func TestTokenBucketSweep(t *testing.T) {
+	clock := newFakeClock()
+
+	l := NewTokenBucketLimiter(time.Minute)
+	defer l.Stop()
+	l.now = clock.Now
+
+	l.Allow("idle", 5)
+	clock.Advance(time.Second * 30)
+	l.Allow("active", 5)
+
+	l.sweep(clock.Now().Add(time.Second * 30))
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("sweep kept a refilled bucket")
+	}
+	if _, ok := l.buckets["active"]; !ok {
+		t.Error("sweep dropped a bucket still refilling")
+	}
+}
@@ -0,0 +1,279 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	AuditPostUpdate           = "post.update"
+	AuditPostDelete           = "post.delete"
+	AuditCommentDelete        = "comment.delete"
+	AuditUserRoleUpdate       = "user.role.update"
+	AuditUserSuspend          = "user.suspend"
+	AuditUserUnsuspend        = "user.unsuspend"
+	AuditUserActivate         = "user.activate"
+	AuditUserLogout           = "user.logout"
+	AuditRoleCreate           = "role.create"
+	AuditRoleUpdate           = "role.update"
+	AuditRoleDelete           = "role.delete"
+	AuditRolePermissionGrant  = "role.permission.grant"
+	AuditRolePermissionRevoke = "role.permission.revoke"
+	AuditReportResolve        = "report.resolve"
+	AuditReportDismiss        = "report.dismiss"
+)
+
+type AuditEvent struct {
+	ID         int64          `json:"id"`
+	ActorID    int64          `json:"actor_id"`
+	Action     string         `json:"action"`
+	TargetType string         `json:"target_type"`
+	TargetID   int64          `json:"target_id"`
+	Before     map[string]any `json:"before,omitempty"`
+	After      map[string]any `json:"after,omitempty"`
+	RequestID  string         `json:"request_id"`
+	IPAddress  string         `json:"ip_address"`
+	CreatedAt  string         `json:"created_at"`
+}
+
+type auditKey string
+
+const auditKeyCtx auditKey = "audit_event"
+
+// WithAuditEvent attaches an event to the context. Store methods that run
+// in withTx write it in the same transaction as the change it describes,
+// so a change is never committed without its event.
+func WithAuditEvent(ctx context.Context, event *AuditEvent) context.Context {
+	return context.WithValue(ctx, auditKeyCtx, event)
+}
+
+func auditEventFromContext(ctx context.Context) *AuditEvent {
+	event, _ := ctx.Value(auditKeyCtx).(*AuditEvent)
+	return event
+}
+
+type AuditStore struct {
+	db *sql.DB
+}
+
+func (s *AuditStore) List(ctx context.Context, aq AuditQuery) ([]AuditEvent, error) {
+	query := `
+		SELECT id, actor_id, action, target_type, target_id, before, after,
+			request_id, ip_address, created_at
+		FROM audit_events
+		WHERE ($1::BIGINT = 0 OR actor_id = $1)
+			AND ($2 = '' OR target_type = $2)
+			AND ($3::BIGINT = 0 OR target_id = $3)
+			AND ($4::TIMESTAMPTZ IS NULL OR created_at >= $4)
+			AND ($5::TIMESTAMPTZ IS NULL OR created_at < $5)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $6 OFFSET $7;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		query,
+		aq.ActorID,
+		aq.TargetType,
+		aq.TargetID,
+		aq.Since,
+		aq.Until,
+		aq.Limit,
+		aq.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		var before, after []byte
+		err := rows.Scan(
+			&e.ID,
+			&e.ActorID,
+			&e.Action,
+			&e.TargetType,
+			&e.TargetID,
+			&before,
+			&after,
+			&e.RequestID,
+			&e.IPAddress,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := unmarshalState(before, &e.Before); err != nil {
+			return nil, err
+		}
+		if err := unmarshalState(after, &e.After); err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func createAuditEvent(ctx context.Context, tx *sql.Tx, event *AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (actor_id, action, target_type, target_id, before, after, request_id, ip_address)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at;
+	`
+	before, after := diffState(event.Before, event.After)
+
+	beforeJSON, err := marshalState(before)
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := marshalState(after)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return tx.QueryRowContext(
+		ctx,
+		query,
+		event.ActorID,
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		beforeJSON,
+		afterJSON,
+		event.RequestID,
+		event.IPAddress,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+// diffState drops the fields that are the same before and after, so an
+// event only holds what the change touched.
+func diffState(before, after map[string]any) (map[string]any, map[string]any) {
+	if before == nil || after == nil {
+		return before, after
+	}
+
+	b := map[string]any{}
+	a := map[string]any{}
+	for k, v := range before {
+		if av, ok := after[k]; !ok || !reflect.DeepEqual(v, av) {
+			b[k] = v
+		}
+	}
+	for k, v := range after {
+		if bv, ok := before[k]; !ok || !reflect.DeepEqual(v, bv) {
+			a[k] = v
+		}
+	}
+
+	return b, a
+}
+
+func marshalState(state map[string]any) ([]byte, error) {
+	if state == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(state)
+}
+
+func unmarshalState(data []byte, state *map[string]any) error {
+	if data == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, state)
+}
+
+type AuditQuery struct {
+	ActorID    int64      `json:"actor_id" validate:"gte=0"`
+	TargetType string     `json:"target_type" validate:"omitempty,oneof=post comment user role report"`
+	TargetID   int64      `json:"target_id" validate:"gte=0"`
+	Since      *time.Time `json:"since"`
+	Until      *time.Time `json:"until"`
+	Limit      int        `json:"limit" validate:"gte=1,lte=100"`
+	Offset     int        `json:"offset" validate:"gte=0"`
+}
+
+func (aq AuditQuery) Parse(r *http.Request) (AuditQuery, error) {
+	qs := r.URL.Query()
+
+	aq.TargetType = qs.Get("target_type")
+
+	actorID := qs.Get("actor_id")
+	if actorID != "" {
+		id, err := strconv.ParseInt(actorID, 10, 64)
+		if err != nil {
+			return aq, err
+		}
+
+		aq.ActorID = id
+	}
+
+	targetID := qs.Get("target_id")
+	if targetID != "" {
+		id, err := strconv.ParseInt(targetID, 10, 64)
+		if err != nil {
+			return aq, err
+		}
+
+		aq.TargetID = id
+	}
+
+	since := qs.Get("since")
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return aq, err
+		}
+
+		aq.Since = &t
+	}
+
+	until := qs.Get("until")
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return aq, err
+		}
+
+		aq.Until = &t
+	}
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return aq, err
+		}
+
+		aq.Limit = l
+	}
+
+	offset := qs.Get("offset")
+	if offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil {
+			return aq, err
+		}
+
+		aq.Offset = o
+	}
+
+	return aq, nil
+}
@@ -0,0 +1,362 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codepnw/social/internal/store"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// Config sets how long each entity stays cached.
+type Config struct {
+	Enabled bool
+	// MemorySize is how many entries each replica keeps in memory in front
+	// of Redis. Without Redis memory is the only tier, and as nothing tells
+	// the replicas of each other's changes it only suits a single replica.
+	MemorySize int
+	// MemoryTTL caps how long an entry stays in memory when Redis is used,
+	// in case an invalidation message was lost
+	MemoryTTL time.Duration
+
+	UserTTL       time.Duration
+	RoleTTL       time.Duration
+	PermissionTTL time.Duration
+	PostTTL       time.Duration
+	// CommentTTL also bounds how long a block or a privacy change takes to
+	// reach comment lists, those are not invalidated
+	CommentTTL time.Duration
+	SessionTTL time.Duration
+	// NegativeTTL is how long a lookup that found nothing is remembered, so
+	// requests for missing ids do not all reach the database
+	NegativeTTL time.Duration
+	// RefreshAhead is the part at the end of a TTL in which a read reloads
+	// the entry in the background, so hot keys do not expire under load
+	RefreshAhead float64
+	// OnRedisError is told of the Redis reads and writes that failed. Reads
+	// go on to the database, so an outage does not fail requests. Optional.
+	OnRedisError func(error)
+}
+
+// entry is what is stored for a key. A lookup that found nothing is stored
+// as Missing.
+type entry struct {
+	Value     json.RawMessage `json:"value,omitempty"`
+	Missing   bool            `json:"missing,omitempty"`
+	RefreshAt time.Time       `json:"refresh_at"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// slot is where an entry lives: a key, or a field of a hash so that a group
+// of entries can be dropped at once.
+type slot struct {
+	key   string
+	field string
+}
+
+func (s slot) String() string {
+	if s.field == "" {
+		return s.key
+	}
+	return s.key + "/" + s.field
+}
+
+// invalidationChannel carries the keys a replica dropped, so the others
+// drop them from memory too.
+const invalidationChannel = "cache-invalidations"
+
+// loader reads entries through memory and Redis to the database.
+// Concurrent misses of a key on this replica share one load.
+type loader struct {
+	// rdb is nil when memory is the only tier
+	rdb    *redis.Client
+	memory *memory
+	cfg    Config
+	group  singleflight.Group
+	gens   generations
+	stats  stats
+}
+
+// generations counts the deletes of each key, in a fixed number of
+// stripes that keys share. A load only caches what it read if no delete of
+// its key happened meanwhile, or it could put back what the delete dropped.
+type generations [64]struct {
+	// mu is held for reading while an entry is written, so a delete waits
+	// for the write and drops it, or the write sees the delete
+	mu sync.RWMutex
+	n  uint64
+}
+
+func (g *generations) stripe(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(g)))
+}
+
+func (g *generations) get(key string) uint64 {
+	i := g.stripe(key)
+
+	g[i].mu.RLock()
+	defer g[i].mu.RUnlock()
+
+	return g[i].n
+}
+
+func (g *generations) bump(keys ...string) {
+	for _, key := range keys {
+		i := g.stripe(key)
+
+		g[i].mu.Lock()
+		g[i].n++
+		g[i].mu.Unlock()
+	}
+}
+
+func newLoader(rdb *redis.Client, cfg Config) *loader {
+	l := &loader{rdb: rdb, cfg: cfg}
+
+	maxAge := cfg.MemoryTTL
+	if rdb == nil {
+		maxAge = 0
+	}
+	l.memory = newMemory(cfg.MemorySize, maxAge, &l.stats)
+
+	return l
+}
+
+// fetch returns the value at s, loading it with load on a miss. A read in
+// the refresh window returns the cached value and reloads it in the
+// background. Values are decoded for each caller, so callers can change
+// them freely.
+func fetch[T any](ctx context.Context, l *loader, s slot, ttl time.Duration, load func(context.Context) (T, error)) (T, error) {
+	var value T
+
+	// taken before the read, a delete after it makes the load start over
+	gen := l.gens.get(s.key)
+	// and a read after a delete does not join a load from before it
+	flight := s.String() + "@" + strconv.FormatUint(gen, 10)
+
+	e, err := l.read(ctx, s)
+	if err != nil {
+		l.redisError(err)
+		e = nil
+	}
+
+	now := time.Now()
+
+	switch {
+	case e == nil || now.After(e.ExpiresAt):
+		v, err, _ := l.group.Do(flight, func() (any, error) {
+			return fill(ctx, l, s, gen, ttl, load)
+		})
+		if err != nil {
+			return value, err
+		}
+		e = v.(*entry)
+	case now.After(e.RefreshAt):
+		// nobody waits for it, DoChan only keeps it to one at a time
+		l.group.DoChan(flight, func() (any, error) {
+			return fill(context.Background(), l, s, gen, ttl, load)
+		})
+	}
+
+	if e.Missing {
+		return value, store.ErrNotFound
+	}
+
+	err = json.Unmarshal(e.Value, &value)
+	return value, err
+}
+
+// fill loads the value and caches it, or caches that there is none. The
+// entry is returned either way, it is only left uncached when the key was
+// deleted since gen.
+func fill[T any](ctx context.Context, l *loader, s slot, gen uint64, ttl time.Duration, load func(context.Context) (T, error)) (*entry, error) {
+	// the load is shared, it must not fail because the first caller left
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), store.QueryTimeoutDuration)
+	defer cancel()
+
+	l.stats.loads.Add(1)
+
+	value, err := load(ctx)
+	if errors.Is(err, store.ErrNotFound) {
+		e := l.newEntry(nil, l.cfg.NegativeTTL)
+		e.Missing = true
+		l.writeSince(ctx, s, gen, e, l.cfg.NegativeTTL)
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	e := l.newEntry(data, ttl)
+	l.writeSince(ctx, s, gen, e, ttl)
+	return e, nil
+}
+
+// writeSince caches the entry unless the key was deleted since gen. The
+// value was loaded already, a failed write only costs a later load.
+func (l *loader) writeSince(ctx context.Context, s slot, gen uint64, e *entry, ttl time.Duration) {
+	i := l.gens.stripe(s.key)
+
+	l.gens[i].mu.RLock()
+	defer l.gens[i].mu.RUnlock()
+
+	if l.gens[i].n != gen {
+		return
+	}
+
+	if err := l.write(ctx, s, e, ttl); err != nil {
+		l.redisError(err)
+	}
+}
+
+func (l *loader) redisError(err error) {
+	l.stats.redisErrors.Add(1)
+
+	if l.cfg.OnRedisError != nil {
+		l.cfg.OnRedisError(err)
+	}
+}
+
+func (l *loader) newEntry(value json.RawMessage, ttl time.Duration) *entry {
+	now := time.Now()
+	ahead := time.Duration(float64(ttl) * l.cfg.RefreshAhead)
+
+	return &entry{
+		Value:     value,
+		RefreshAt: now.Add(ttl - ahead),
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+// read returns the entry at s, or nil on a miss.
+func (l *loader) read(ctx context.Context, s slot) (*entry, error) {
+	now := time.Now()
+
+	if e := l.memory.get(s, now); e != nil {
+		l.stats.memoryHits.Add(1)
+		return e, nil
+	}
+	l.stats.memoryMisses.Add(1)
+
+	if l.rdb == nil {
+		return nil, nil
+	}
+
+	var data []byte
+	var err error
+
+	if s.field == "" {
+		data, err = l.rdb.Get(ctx, s.key).Bytes()
+	} else {
+		data, err = l.rdb.HGet(ctx, s.key, s.field).Bytes()
+	}
+
+	if err == redis.Nil {
+		l.stats.redisMisses.Add(1)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	l.stats.redisHits.Add(1)
+
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+
+	l.memory.add(s, &e, now)
+
+	return &e, nil
+}
+
+func (l *loader) write(ctx context.Context, s slot, e *entry, ttl time.Duration) error {
+	l.memory.add(s, e, time.Now())
+
+	if l.rdb == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if s.field == "" {
+		return l.rdb.SetEx(ctx, s.key, data, ttl).Err()
+	}
+
+	// fields do not expire on their own, the hash goes ttl after its first
+	// field so it cannot keep growing
+	pipe := l.rdb.TxPipeline()
+	pipe.HSet(ctx, s.key, s.field, data)
+	pipe.ExpireNX(ctx, s.key, ttl)
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// delete drops the keys, the hash keys with all their fields, from every
+// replica. Loads of the keys in flight do not cache what they read, and
+// later reads do not wait on them.
+func (l *loader) delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	l.gens.bump(keys...)
+	l.memory.delete(keys...)
+
+	if l.rdb == nil {
+		return nil
+	}
+
+	pipe := l.rdb.Pipeline()
+	pipe.Del(ctx, keys...)
+	pipe.Publish(ctx, invalidationChannel, strings.Join(keys, "\n"))
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// listen drops from memory the keys the replicas invalidate until ctx is
+// done. The client reconnects on its own, messages sent meanwhile are lost
+// and the entries they were about wait out MemoryTTL.
+func (l *loader) listen(ctx context.Context) {
+	if l.rdb == nil {
+		return
+	}
+
+	sub := l.rdb.Subscribe(ctx, invalidationChannel)
+	defer sub.Close()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			keys := strings.Split(msg.Payload, "\n")
+			l.gens.bump(keys...)
+			l.memory.delete(keys...)
+			l.stats.invalidations.Add(int64(len(keys)))
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codepnw/social/internal/store"
+)
+
+type CommentStore struct {
+	l    *loader
+	load func(ctx context.Context, postID, viewerID int64) ([]store.Comment, error)
+}
+
+// GetByPostID returns the comments of the post the viewer may see. The
+// lists of a post are kept in one hash, one field per viewer, so they are
+// dropped together.
+func (s *CommentStore) GetByPostID(ctx context.Context, postID, viewerID int64) ([]store.Comment, error) {
+	slot := slot{
+		key:   commentsKey(postID),
+		field: fmt.Sprintf("%d", viewerID),
+	}
+
+	return fetch(ctx, s.l, slot, s.l.cfg.CommentTTL, func(ctx context.Context) ([]store.Comment, error) {
+		return s.load(ctx, postID, viewerID)
+	})
+}
+
+// Delete drops the comment lists of the post, for every viewer.
+func (s *CommentStore) Delete(ctx context.Context, postID int64) error {
+	return s.l.delete(ctx, commentsKey(postID))
+}
+
+func commentsKey(postID int64) string {
+	return fmt.Sprintf("post-comments-%d", postID)
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// memory is the in-process tier in front of Redis: a size bounded LRU of
+// entries. Items are grouped by key, so a hash key drops with all its
+// fields.
+type memory struct {
+	mu   sync.Mutex
+	size int
+	// maxAge caps how long an item is kept, zero keeps it until its entry
+	// expires
+	maxAge time.Duration
+	// order has the most recently used item at the front
+	order *list.List
+	items map[string]map[string]*list.Element
+	stats *stats
+}
+
+type memoryItem struct {
+	slot      slot
+	entry     *entry
+	expiresAt time.Time
+}
+
+func newMemory(size int, maxAge time.Duration, stats *stats) *memory {
+	return &memory{
+		size:   size,
+		maxAge: maxAge,
+		order:  list.New(),
+		items:  map[string]map[string]*list.Element{},
+		stats:  stats,
+	}
+}
+
+// get returns the entry at s, or nil when it is not kept or too old.
+// Entries are shared between callers and must not be changed.
+func (m *memory) get(s slot, now time.Time) *entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[s.key][s.field]
+	if !ok {
+		return nil
+	}
+
+	item := el.Value.(*memoryItem)
+	if now.After(item.expiresAt) {
+		m.remove(el)
+		return nil
+	}
+
+	m.order.MoveToFront(el)
+	return item.entry
+}
+
+func (m *memory) add(s slot, e *entry, now time.Time) {
+	if m.size <= 0 {
+		return
+	}
+
+	expiresAt := e.ExpiresAt
+	if m.maxAge > 0 && now.Add(m.maxAge).Before(expiresAt) {
+		expiresAt = now.Add(m.maxAge)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[s.key][s.field]; ok {
+		item := el.Value.(*memoryItem)
+		item.entry = e
+		item.expiresAt = expiresAt
+		m.order.MoveToFront(el)
+		return
+	}
+
+	fields, ok := m.items[s.key]
+	if !ok {
+		fields = map[string]*list.Element{}
+		m.items[s.key] = fields
+	}
+	fields[s.field] = m.order.PushFront(&memoryItem{slot: s, entry: e, expiresAt: expiresAt})
+
+	for m.order.Len() > m.size {
+		m.remove(m.order.Back())
+		m.stats.evictions.Add(1)
+	}
+}
+
+// delete drops the keys with all their fields.
+func (m *memory) delete(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		for _, el := range m.items[key] {
+			m.order.Remove(el)
+		}
+		delete(m.items, key)
+	}
+}
+
+func (m *memory) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.order.Len()
+}
+
+func (m *memory) remove(el *list.Element) {
+	s := m.order.Remove(el).(*memoryItem).slot
+
+	delete(m.items[s.key], s.field)
+	if len(m.items[s.key]) == 0 {
+		delete(m.items, s.key)
+	}
+}
@@ -0,0 +1,26 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+)
+
+type PermissionStore struct {
+	l    *loader
+	load func(context.Context, int64) ([]string, error)
+}
+
+// Get returns the permission names of a role.
+func (s *PermissionStore) Get(ctx context.Context, roleID int64) ([]string, error) {
+	return fetch(ctx, s.l, permissionSlot(roleID), s.l.cfg.PermissionTTL, func(ctx context.Context) ([]string, error) {
+		return s.load(ctx, roleID)
+	})
+}
+
+func (s *PermissionStore) Delete(ctx context.Context, roleID int64) error {
+	return s.l.delete(ctx, permissionSlot(roleID).key)
+}
+
+func permissionSlot(roleID int64) slot {
+	return slot{key: fmt.Sprintf("role-permissions-%d", roleID)}
+}
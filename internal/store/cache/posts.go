@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codepnw/social/internal/store"
+)
+
+type PostStore struct {
+	l    *loader
+	load func(context.Context, int64) (*store.Post, error)
+}
+
+// Get returns the post, read through from the database. Hidden posts fail
+// with store.ErrNotFound, who may see the post is left to the caller.
+func (s *PostStore) Get(ctx context.Context, postID int64) (*store.Post, error) {
+	return fetch(ctx, s.l, postSlot(postID), s.l.cfg.PostTTL, func(ctx context.Context) (*store.Post, error) {
+		return s.load(ctx, postID)
+	})
+}
+
+func (s *PostStore) Delete(ctx context.Context, postIDs ...int64) error {
+	keys := make([]string, len(postIDs))
+	for i, id := range postIDs {
+		keys[i] = postSlot(id).key
+	}
+
+	return s.l.delete(ctx, keys...)
+}
+
+func postSlot(postID int64) slot {
+	return slot{key: fmt.Sprintf("post-%d", postID)}
+}
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codepnw/social/internal/store"
+)
+
+type RoleStore struct {
+	l    *loader
+	load func(context.Context, int64) (*store.Role, error)
+}
+
+func (s *RoleStore) Get(ctx context.Context, roleID int64) (*store.Role, error) {
+	return fetch(ctx, s.l, roleSlot(roleID), s.l.cfg.RoleTTL, func(ctx context.Context) (*store.Role, error) {
+		return s.load(ctx, roleID)
+	})
+}
+
+func (s *RoleStore) Delete(ctx context.Context, roleID int64) error {
+	return s.l.delete(ctx, roleSlot(roleID).key)
+}
+
+func roleSlot(roleID int64) slot {
+	return slot{key: fmt.Sprintf("role-%d", roleID)}
+}
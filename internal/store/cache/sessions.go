@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codepnw/social/internal/store"
+)
+
+type SessionStore struct {
+	l    *loader
+	load func(context.Context, string) (*store.Session, error)
+}
+
+// Get returns the session, revoked and expired ones included. The TTL
+// bounds how long a session revoked on another replica without reaching
+// Redis could still be used.
+func (s *SessionStore) Get(ctx context.Context, id string) (*store.Session, error) {
+	return fetch(ctx, s.l, sessionSlot(id), s.l.cfg.SessionTTL, func(ctx context.Context) (*store.Session, error) {
+		return s.load(ctx, id)
+	})
+}
+
+func (s *SessionStore) Delete(ctx context.Context, ids ...string) error {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionSlot(id).key
+	}
+
+	return s.l.delete(ctx, keys...)
+}
+
+func sessionSlot(id string) slot {
+	return slot{key: fmt.Sprintf("session-%s", id)}
+}
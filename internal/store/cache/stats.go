@@ -0,0 +1,34 @@
+package cache
+
+import "sync/atomic"
+
+// Stats counts what the cache did since the start. Lookups go to memory
+// first, memory misses to Redis, and Redis misses end in a load.
+type Stats struct {
+	MemoryHits      int64 `json:"memory_hits"`
+	MemoryMisses    int64 `json:"memory_misses"`
+	MemoryEvictions int64 `json:"memory_evictions"`
+	MemoryEntries   int   `json:"memory_entries"`
+	RedisHits       int64 `json:"redis_hits"`
+	RedisMisses     int64 `json:"redis_misses"`
+	// RedisErrors are the reads and writes that failed, reads went on to
+	// the database
+	RedisErrors int64 `json:"redis_errors"`
+	// Loads are the reads from the database, concurrent misses of a key
+	// share one
+	Loads int64 `json:"loads"`
+	// Invalidations are the keys dropped on messages from the replicas,
+	// this one included
+	Invalidations int64 `json:"invalidations"`
+}
+
+type stats struct {
+	memoryHits    atomic.Int64
+	memoryMisses  atomic.Int64
+	evictions     atomic.Int64
+	redisHits     atomic.Int64
+	redisMisses   atomic.Int64
+	redisErrors   atomic.Int64
+	loads         atomic.Int64
+	invalidations atomic.Int64
+}
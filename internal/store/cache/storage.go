@@ -7,15 +7,69 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// Storage reads through memory and Redis to the database. Lookups that find
+// nothing fail with store.ErrNotFound, and are cached too.
 type Storage struct {
 	Users interface {
 		Get(context.Context, int64) (*store.User, error)
-		Set(context.Context, *store.User) error
+		Delete(context.Context, int64) error
 	}
+	Roles interface {
+		Get(context.Context, int64) (*store.Role, error)
+		Delete(context.Context, int64) error
+	}
+	Permissions interface {
+		Get(context.Context, int64) ([]string, error)
+		Delete(context.Context, int64) error
+	}
+	Posts interface {
+		Get(context.Context, int64) (*store.Post, error)
+		Delete(context.Context, ...int64) error
+	}
+	Comments interface {
+		GetByPostID(ctx context.Context, postID, viewerID int64) ([]store.Comment, error)
+		Delete(ctx context.Context, postID int64) error
+	}
+	Sessions interface {
+		Get(context.Context, string) (*store.Session, error)
+		Delete(context.Context, ...string) error
+	}
+
+	l *loader
 }
 
-func NewRedisStorage(rdb *redis.Client) Storage {
+// NewStorage caches in memory in front of rdb, or only in memory when rdb
+// is nil.
+func NewStorage(rdb *redis.Client, s store.Storage, cfg Config) Storage {
+	l := newLoader(rdb, cfg)
+
 	return Storage{
-		Users: &UserStore{rdb: rdb},
+		Users:       &UserStore{l: l, load: s.Users.GetByID},
+		Roles:       &RoleStore{l: l, load: s.Roles.GetByID},
+		Permissions: &PermissionStore{l: l, load: s.Permissions.GetByRoleID},
+		Posts:       &PostStore{l: l, load: s.Posts.GetByID},
+		Comments:    &CommentStore{l: l, load: s.Comments.GetByPostID},
+		Sessions:    &SessionStore{l: l, load: s.Sessions.GetByID},
+
+		l: l,
+	}
+}
+
+// Listen keeps memory coherent with the other replicas until ctx is done.
+func (s Storage) Listen(ctx context.Context) {
+	s.l.listen(ctx)
+}
+
+func (s Storage) Stats() Stats {
+	return Stats{
+		MemoryHits:      s.l.stats.memoryHits.Load(),
+		MemoryMisses:    s.l.stats.memoryMisses.Load(),
+		MemoryEvictions: s.l.stats.evictions.Load(),
+		MemoryEntries:   s.l.memory.len(),
+		RedisHits:       s.l.stats.redisHits.Load(),
+		RedisMisses:     s.l.stats.redisMisses.Load(),
+		RedisErrors:     s.l.stats.redisErrors.Load(),
+		Loads:           s.l.stats.loads.Load(),
+		Invalidations:   s.l.stats.invalidations.Load(),
 	}
 }
@@ -2,48 +2,28 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"time"
 
 	"github.com/codepnw/social/internal/store"
-	"github.com/redis/go-redis/v9"
 )
 
 type UserStore struct {
-	rdb *redis.Client
+	l    *loader
+	load func(context.Context, int64) (*store.User, error)
 }
 
-const UserExpTime = time.Minute
-
+// Get returns the user, read through from the database. Users that are
+// missing, inactive or suspended fail with store.ErrNotFound.
 func (s *UserStore) Get(ctx context.Context, userID int64) (*store.User, error) {
-	cacheKey := fmt.Sprintf("user-%d", userID)
-
-	data, err := s.rdb.Get(ctx, cacheKey).Result()
-	if err == redis.Nil {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
-	}
-
-	var user store.User
-	if data != "" {
-		err := json.Unmarshal([]byte(data), &user)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return &user, nil
+	return fetch(ctx, s.l, userSlot(userID), s.l.cfg.UserTTL, func(ctx context.Context) (*store.User, error) {
+		return s.load(ctx, userID)
+	})
 }
 
-func (s *UserStore) Set(ctx context.Context, user *store.User) error {
-	cacheKey := fmt.Sprintf("user-%v", user.ID)
-
-	json, err := json.Marshal(user)
-	if err != nil {
-		return err
-	}
+func (s *UserStore) Delete(ctx context.Context, userID int64) error {
+	return s.l.delete(ctx, userSlot(userID).key)
+}
 
-	return s.rdb.SetEx(ctx, cacheKey, json, UserExpTime).Err()
+func userSlot(userID int64) slot {
+	return slot{key: fmt.Sprintf("user-%d", userID)}
 }
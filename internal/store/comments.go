@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 )
 
 type Comment struct {
@@ -44,19 +45,25 @@ func (s *CommentStore) Create(ctx context.Context, comment *Comment) error {
 	return nil
 }
 
-func (s *CommentStore) GetByPostID(ctx context.Context, postID int64) ([]Comment, error) {
+// GetByPostID returns the comments on a post that the viewer is allowed to
+// see, leaving out authors hidden by a block or mute and private authors
+// the viewer does not follow.
+func (s *CommentStore) GetByPostID(ctx context.Context, postID, viewerID int64) ([]Comment, error) {
 	query := `
 		SELECT 
 			c.id, c.post_id, c.user_id, c.content, c.created_at, users.username, users.id 
 		FROM comments c
 		JOIN users ON users.id =  c.user_id
 		WHERE c.post_id = $1
+			AND NOT c.is_hidden
+			AND ` + relationshipFilter("c.user_id", "$2") + `
+			AND ` + privacyFilter("c.user_id", "$2") + `
 		ORDER BY c.created_at DESC;
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 	
-	rows, err := s.db.QueryContext(ctx, query, postID)
+	rows, err := s.db.QueryContext(ctx, query, postID, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -84,3 +91,48 @@ func (s *CommentStore) GetByPostID(ctx context.Context, postID int64) ([]Comment
 
 	return comments, nil
 }
+
+func (s *CommentStore) GetByID(ctx context.Context, postID, commentID int64) (*Comment, error) {
+	query := `
+		SELECT id, post_id, user_id, content, created_at
+		FROM comments
+		WHERE id = $1 AND post_id = $2 AND NOT is_hidden;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var c Comment
+	err := s.db.QueryRowContext(ctx, query, commentID, postID).Scan(
+		&c.ID,
+		&c.PostID,
+		&c.UserID,
+		&c.Content,
+		&c.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+func (s *CommentStore) Delete(ctx context.Context, commentID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM comments WHERE id = $1;`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, commentID)
+		if err != nil {
+			return err
+		}
+
+		return requireRows(res)
+	})
+}
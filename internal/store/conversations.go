@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type Conversation struct {
+	ID            int64  `json:"id"`
+	UserOneID     int64  `json:"user_one_id"`
+	UserTwoID     int64  `json:"user_two_id"`
+	CreatedAt     string `json:"created_at"`
+	LastMessageAt string `json:"last_message_at"`
+	UnreadCount   int    `json:"unread_count"`
+}
+
+func (c *Conversation) HasParticipant(userID int64) bool {
+	return c.UserOneID == userID || c.UserTwoID == userID
+}
+
+type ConversationStore struct {
+	db *sql.DB
+}
+
+// GetOrCreate returns the conversation between the two users, creating it
+// the first time they talk. Pairs are stored with the lower ID first so
+// that each pair of users has exactly one conversation. It also reports
+// whether the conversation was created.
+func (s *ConversationStore) GetOrCreate(ctx context.Context, userID, otherID int64) (*Conversation, bool, error) {
+	one, two := userID, otherID
+	if one > two {
+		one, two = two, one
+	}
+
+	query := `
+		INSERT INTO conversations (user_one_id, user_two_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_one_id, user_two_id) DO NOTHING;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, one, two)
+	if err != nil {
+		return nil, false, err
+	}
+
+	created, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	query = `
+		SELECT id, user_one_id, user_two_id, created_at, last_message_at
+		FROM conversations
+		WHERE user_one_id = $1 AND user_two_id = $2;
+	`
+	conversation := &Conversation{}
+	err = s.db.QueryRowContext(ctx, query, one, two).Scan(
+		&conversation.ID,
+		&conversation.UserOneID,
+		&conversation.UserTwoID,
+		&conversation.CreatedAt,
+		&conversation.LastMessageAt,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return conversation, created > 0, nil
+}
+
+func (s *ConversationStore) GetByID(ctx context.Context, id int64) (*Conversation, error) {
+	query := `
+		SELECT id, user_one_id, user_two_id, created_at, last_message_at
+		FROM conversations
+		WHERE id = $1;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	conversation := &Conversation{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&conversation.ID,
+		&conversation.UserOneID,
+		&conversation.UserTwoID,
+		&conversation.CreatedAt,
+		&conversation.LastMessageAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return conversation, nil
+}
+
+// GetByUserID lists the user's conversations, most recently active first,
+// with the number of messages the user has not read yet.
+func (s *ConversationStore) GetByUserID(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]Conversation, error) {
+	query := `
+		SELECT
+			c.id, c.user_one_id, c.user_two_id, c.created_at, c.last_message_at,
+			(
+				SELECT COUNT(*) FROM messages m
+				WHERE m.conversation_id = c.id AND m.sender_id <> $1 AND m.read_at IS NULL
+			) AS unread_count
+		FROM conversations c
+		WHERE c.user_one_id = $1 OR c.user_two_id = $1
+		ORDER BY c.last_message_at DESC, c.id DESC
+		LIMIT $2 OFFSET $3;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, fq.Limit, fq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		var c Conversation
+		err := rows.Scan(
+			&c.ID,
+			&c.UserOneID,
+			&c.UserTwoID,
+			&c.CreatedAt,
+			&c.LastMessageAt,
+			&c.UnreadCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, c)
+	}
+
+	return conversations, rows.Err()
+}
@@ -13,32 +13,235 @@ type Follower struct {
 	CreatedAt string `json:"created_at"`
 }
 
+type FollowRequest struct {
+	UserID      int64  `json:"user_id"`
+	RequesterID int64  `json:"requester_id"`
+	CreatedAt   string `json:"created_at"`
+	Requester   User   `json:"requester"`
+}
+
 type FollowerStore struct {
 	db *sql.DB
 }
 
 func (s *FollowerStore) Follow(ctx context.Context, followerID, userID int64) error {
-	query := `INSERT INTO followers (user_id, follower_id) VALUES ($1, $2);`
-
+	// a block in either direction prevents the follow
+	query := `
+		INSERT INTO followers (user_id, follower_id)
+		SELECT $1, $2
+		WHERE NOT EXISTS (
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		);
+	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	_, err := s.db.ExecContext(ctx, query, userID, followerID)
+	res, err := s.db.ExecContext(ctx, query, userID, followerID)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
 			return ErrConflict
 		}
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrBlocked
 	}
 
 	return nil
 }
 
+// Unfollow removes the follow, or withdraws the pending follow request if
+// the user is private and has not answered yet.
 func (s *FollowerStore) Unfollow(ctx context.Context, followerID, userID int64) error {
-	query := `DELETE FROM followers WHERE user_id = $1 AND follower_id = $2;`
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM followers WHERE user_id = $1 AND follower_id = $2;`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, query, userID, followerID); err != nil {
+			return err
+		}
+
+		query = `DELETE FROM follow_requests WHERE user_id = $1 AND requester_id = $2;`
+
+		_, err := tx.ExecContext(ctx, query, userID, followerID)
+		return err
+	})
+}
+
+// IsMutual reports whether the two users follow each other.
+func (s *FollowerStore) IsMutual(ctx context.Context, userID, otherID int64) (bool, error) {
+	query := `
+		SELECT COUNT(*) = 2 FROM followers
+		WHERE (user_id = $1 AND follower_id = $2) OR (user_id = $2 AND follower_id = $1);
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var mutual bool
+	err := s.db.QueryRowContext(ctx, query, userID, otherID).Scan(&mutual)
+	return mutual, err
+}
+
+// Request asks a private user to be followed. It fails with ErrConflict when
+// the requester already follows the user or already asked.
+func (s *FollowerStore) Request(ctx context.Context, requesterID, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// the checks are part of the insert, so an accept or block that
+		// lands meanwhile cannot slip in between
+		query := `
+			INSERT INTO follow_requests (user_id, requester_id)
+			SELECT $1, $2
+			WHERE NOT EXISTS (
+				SELECT 1 FROM followers WHERE user_id = $1 AND follower_id = $2
+			) AND NOT EXISTS (
+				SELECT 1 FROM user_blocks
+				WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+			);
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, userID, requesterID)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows > 0 {
+			return nil
+		}
+
+		following, err := s.isFollowing(ctx, tx, requesterID, userID)
+		if err != nil {
+			return err
+		}
 
+		if following {
+			return ErrConflict
+		}
+
+		return ErrBlocked
+	})
+}
+
+// GetRequests lists the pending follow requests sent to the user.
+func (s *FollowerStore) GetRequests(ctx context.Context, userID int64) ([]FollowRequest, error) {
+	query := `
+		SELECT fr.user_id, fr.requester_id, fr.created_at, u.id, u.username
+		FROM follow_requests fr
+		JOIN users u ON u.id = fr.requester_id
+		WHERE fr.user_id = $1
+		ORDER BY fr.created_at DESC;
+	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	_, err := s.db.ExecContext(ctx, query, userID, followerID)
-	return err
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []FollowRequest{}
+	for rows.Next() {
+		var fr FollowRequest
+		err := rows.Scan(
+			&fr.UserID,
+			&fr.RequesterID,
+			&fr.CreatedAt,
+			&fr.Requester.ID,
+			&fr.Requester.Username,
+		)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, fr)
+	}
+
+	return requests, rows.Err()
+}
+
+// ApproveRequest turns a pending follow request into a follow.
+func (s *FollowerStore) ApproveRequest(ctx context.Context, userID, requesterID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.deleteRequest(ctx, tx, userID, requesterID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO followers (user_id, follower_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		_, err := tx.ExecContext(ctx, query, userID, requesterID)
+		return err
+	})
+}
+
+func (s *FollowerStore) RejectRequest(ctx context.Context, userID, requesterID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		return s.deleteRequest(ctx, tx, userID, requesterID)
+	})
+}
+
+// CanView reports whether the viewer may see content written by the author:
+// always for public accounts, and only for approved followers of private
+// ones.
+func (s *FollowerStore) CanView(ctx context.Context, authorID, viewerID int64) (bool, error) {
+	// bare parameters on both sides of the comparison would be typed as text
+	query := `SELECT ` + privacyFilter("$1::BIGINT", "$2::BIGINT") + `;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var visible bool
+	err := s.db.QueryRowContext(ctx, query, authorID, viewerID).Scan(&visible)
+	return visible, err
+}
+
+func (s *FollowerStore) isFollowing(ctx context.Context, tx *sql.Tx, followerID, userID int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM followers WHERE user_id = $1 AND follower_id = $2);`
+
+	var following bool
+	err := tx.QueryRowContext(ctx, query, userID, followerID).Scan(&following)
+	return following, err
+}
+
+func (s *FollowerStore) deleteRequest(ctx context.Context, tx *sql.Tx, userID, requesterID int64) error {
+	query := `DELETE FROM follow_requests WHERE user_id = $1 AND requester_id = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, userID, requesterID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
 }
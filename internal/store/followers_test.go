@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFollowerStoreCanView(t *testing.T) {
+	db := newTestDB(t)
+	s := &FollowerStore{db: db}
+	ctx := context.Background()
+
+	public := createTestUser(t, db, "public", false)
+	private := createTestUser(t, db, "private", true)
+	follower := createTestUser(t, db, "follower", false)
+	requester := createTestUser(t, db, "requester", false)
+	stranger := createTestUser(t, db, "stranger", false)
+
+	if err := s.Request(ctx, follower, private); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ApproveRequest(ctx, private, follower); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Request(ctx, requester, private); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		author   int64
+		viewer   int64
+		wantView bool
+	}{
+		{"public account", public, stranger, true},
+		{"own private account", private, private, true},
+		{"approved follower", private, follower, true},
+		{"pending request", private, requester, false},
+		{"stranger", private, stranger, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.CanView(ctx, tt.author, tt.viewer)
+			if err != nil {
+				t.Fatalf("CanView() error = %v", err)
+			}
+
+			if got != tt.wantView {
+				t.Errorf("CanView() = %v, want %v", got, tt.wantView)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still in progress")
+	ErrIdempotencyMismatch   = errors.New("idempotency key was already used for a different request")
+)
+
+// IdempotentResponse is the first response sent for an idempotency key,
+// replayed to retries of the same request.
+type IdempotentResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+type IdempotencyStore struct {
+	db *sql.DB
+}
+
+// Begin claims the key for a request. It returns nil when the caller should
+// run the request, or the stored response when it already ran. A key that
+// is still running fails with ErrIdempotencyInProgress, and a key reused
+// for another request fails with ErrIdempotencyMismatch. Expired keys are
+// claimed again as new.
+func (s *IdempotencyStore) Begin(ctx context.Context, scope, key, fingerprint string, ttl time.Duration) (*IdempotentResponse, error) {
+	query := `
+		INSERT INTO idempotency_keys (scope, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (scope, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status_code = NULL, headers = NULL, body = NULL,
+			created_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+		RETURNING scope;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var claimed string
+	err := s.db.QueryRowContext(ctx, query, scope, key, fingerprint, ttl.Seconds()).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query = `
+		SELECT fingerprint, status_code, headers, body
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2;
+	`
+	var (
+		storedFingerprint string
+		statusCode        sql.NullInt32
+		header            []byte
+		resp              IdempotentResponse
+	)
+
+	err = s.db.QueryRowContext(ctx, query, scope, key).Scan(&storedFingerprint, &statusCode, &header, &resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if storedFingerprint != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+
+	if !statusCode.Valid {
+		return nil, ErrIdempotencyInProgress
+	}
+
+	resp.StatusCode = int(statusCode.Int32)
+	if err := json.Unmarshal(header, &resp.Header); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// Complete stores the response of a request that claimed the key.
+func (s *IdempotencyStore) Complete(ctx context.Context, scope, key string, resp *IdempotentResponse) error {
+	query := `
+		UPDATE idempotency_keys SET status_code = $3, headers = $4, body = $5
+		WHERE scope = $1 AND key = $2;
+	`
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err = s.db.ExecContext(ctx, query, scope, key, resp.StatusCode, header, resp.Body)
+	return err
+}
+
+// PurgeExpired deletes the keys past their TTL and returns how many there
+// were. Begin already ignores them, this only keeps the table small.
+func (s *IdempotencyStore) PurgeExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM idempotency_keys WHERE expires_at < NOW();`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Delete releases a key whose request failed, so it can be retried.
+func (s *IdempotencyStore) Delete(ctx context.Context, scope, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, scope, key)
+	return err
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OAuthState is a sign in waiting for the provider to call back.
+type OAuthState struct {
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+}
+
+type IdentityStore struct {
+	db *sql.DB
+}
+
+func (s *IdentityStore) CreateState(ctx context.Context, state string, st *OAuthState, exp time.Duration) error {
+	query := `
+		INSERT INTO oauth_states (state, provider, code_verifier, nonce, expiry)
+		VALUES ($1, $2, $3, $4, $5);
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, tokenHash(state), st.Provider, st.CodeVerifier, st.Nonce, time.Now().Add(exp))
+	return err
+}
+
+// ConsumeState returns and deletes the sign in of the state, so a callback
+// cannot be replayed. Unknown and expired states fail with ErrNotFound.
+func (s *IdentityStore) ConsumeState(ctx context.Context, state string) (*OAuthState, error) {
+	query := `
+		DELETE FROM oauth_states
+		WHERE state = $1 AND expiry > NOW()
+		RETURNING provider, code_verifier, nonce;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	st := &OAuthState{}
+	err := s.db.QueryRowContext(ctx, query, tokenHash(state)).Scan(&st.Provider, &st.CodeVerifier, &st.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return st, nil
+}
+
+// PurgeExpiredStates deletes the sign ins that never came back from the
+// provider and returns how many there were.
+func (s *IdentityStore) PurgeExpiredStates(ctx context.Context) (int64, error) {
+	query := `DELETE FROM oauth_states WHERE expiry < NOW();`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GetUserID returns the user the provider identity is linked to.
+func (s *IdentityStore) GetUserID(ctx context.Context, provider, subject string) (int64, error) {
+	query := `SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// LinkByEmail links the identity to the user with the email, which the
+// provider verified. Fails with ErrNotFound when no user that is not
+// suspended has the email.
+func (s *IdentityStore) LinkByEmail(ctx context.Context, provider, subject, email string) (int64, error) {
+	var userID int64
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var active bool
+		query := `SELECT id, is_active FROM users WHERE email = $1 AND is_suspended = false FOR UPDATE;`
+		if err := tx.QueryRowContext(ctx, query, email).Scan(&userID, &active); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if !active {
+			if err := claimUser(ctx, tx, userID); err != nil {
+				return err
+			}
+		}
+
+		return createIdentity(ctx, tx, userID, provider, subject, email)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// CreateUser provisions an active user for the identity, without the
+// invitation step: the provider verified the email.
+func (s *IdentityStore) CreateUser(ctx context.Context, user *User, provider, subject string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO users (username, password, email, role_id, is_active)
+			VALUES ($1, $2, $3, (SELECT id FROM roles WHERE name = 'user'), true)
+			RETURNING id, created_at;
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, user.Username, user.Password.hash, user.Email).Scan(&user.ID, &user.CreatedAt)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				switch pqErr.Constraint {
+				case "users_email_key":
+					return ErrDuplicateEmail
+				case "users_username_key":
+					return ErrDuplicateUsername
+				}
+			}
+			return err
+		}
+		user.IsActive = true
+
+		return createIdentity(ctx, tx, user.ID, provider, subject, user.Email)
+	})
+}
+
+// claimUser activates a user who never activated their account for the
+// owner of the email, whom the provider verified. Anyone could have
+// registered the email, so the password and whatever else they set up to
+// sign in is dropped.
+func claimUser(ctx context.Context, tx *sql.Tx, userID int64) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	var pw password
+	if err := pw.Set(base64.RawURLEncoding.EncodeToString(b)); err != nil {
+		return err
+	}
+
+	query := `UPDATE users SET is_active = true, password = $2 WHERE id = $1;`
+	if _, err := tx.ExecContext(ctx, query, userID, pw.hash); err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM user_invitations WHERE user_id = $1;`,
+		`DELETE FROM sessions WHERE user_id = $1;`,
+		`DELETE FROM personal_access_tokens WHERE user_id = $1;`,
+		`DELETE FROM user_totp WHERE user_id = $1;`,
+		`DELETE FROM user_recovery_codes WHERE user_id = $1;`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createIdentity(ctx context.Context, tx *sql.Tx, userID int64, provider, subject, email string) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, $4);
+	`
+	_, err := tx.ExecContext(ctx, query, provider, subject, userID, email)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return nil
+}
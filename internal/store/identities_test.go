@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIdentityStoreLinkByEmail(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserStore{db: db}
+	identities := &IdentityStore{db: db}
+	ctx := context.Background()
+
+	register := func(t *testing.T, username, pw string) int64 {
+		t.Helper()
+
+		user := &User{Username: username, Email: username + "@example.com"}
+		if err := user.Password.Set(pw); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := users.CreateAndInvite(ctx, user, "invitation-"+username, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		return user.ID
+	}
+
+	passwordMatches := func(t *testing.T, userID int64, pw string) bool {
+		t.Helper()
+
+		var p password
+		if err := db.QueryRow(`SELECT password FROM users WHERE id = $1;`, userID).Scan(&p.hash); err != nil {
+			t.Fatal(err)
+		}
+
+		return p.Compare(pw) == nil
+	}
+
+	count := func(t *testing.T, query string, userID int64) int {
+		t.Helper()
+
+		var n int
+		if err := db.QueryRow(query, userID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+
+		return n
+	}
+
+	t.Run("never activated", func(t *testing.T) {
+		// someone registered the victim's email with a password of their own
+		id := register(t, "victim", "chosen-by-attacker")
+
+		linked, err := identities.LinkByEmail(ctx, "mock", "victim-subject", "victim@example.com")
+		if err != nil {
+			t.Fatalf("LinkByEmail() error = %v", err)
+		}
+		if linked != id {
+			t.Fatalf("LinkByEmail() = %d, want %d", linked, id)
+		}
+
+		if passwordMatches(t, id, "chosen-by-attacker") {
+			t.Error("the password set before the email was verified still signs in")
+		}
+
+		if _, err := users.GetByEmail(ctx, "victim@example.com"); err != nil {
+			t.Errorf("user not active after linking: %v", err)
+		}
+
+		if n := count(t, `SELECT COUNT(*) FROM user_invitations WHERE user_id = $1;`, id); n != 0 {
+			t.Errorf("%d invitations left, want none", n)
+		}
+
+		if got, err := identities.GetUserID(ctx, "mock", "victim-subject"); err != nil || got != id {
+			t.Errorf("GetUserID() = %d, %v, want %d", got, err, id)
+		}
+	})
+
+	t.Run("active", func(t *testing.T) {
+		id := register(t, "owner", "owners-password")
+		if _, err := db.Exec(`UPDATE users SET is_active = true WHERE id = $1;`, id); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := identities.LinkByEmail(ctx, "mock", "owner-subject", "owner@example.com"); err != nil {
+			t.Fatalf("LinkByEmail() error = %v", err)
+		}
+
+		if !passwordMatches(t, id, "owners-password") {
+			t.Error("linking changed the password of an active user")
+		}
+	})
+
+	t.Run("suspended", func(t *testing.T) {
+		id := register(t, "suspended", "password")
+		if _, err := db.Exec(`UPDATE users SET is_active = true, is_suspended = true WHERE id = $1;`, id); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := identities.LinkByEmail(ctx, "mock", "suspended-subject", "suspended@example.com"); err != ErrNotFound {
+			t.Errorf("LinkByEmail() error = %v, want %v", err, ErrNotFound)
+		}
+
+		if _, err := identities.GetUserID(ctx, "mock", "suspended-subject"); err != ErrNotFound {
+			t.Errorf("GetUserID() error = %v, want the identity unlinked", err)
+		}
+	})
+
+	t.Run("no user", func(t *testing.T) {
+		_, err := identities.LinkByEmail(ctx, "mock", "nobody-subject", "nobody@example.com")
+		if err != ErrNotFound {
+			t.Errorf("LinkByEmail() error = %v, want %v", err, ErrNotFound)
+		}
+	})
+}
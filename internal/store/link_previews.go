@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// Scan reads the JSON built by linkPreviewColumn.
+func (lp *LinkPreview) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, lp)
+	case string:
+		return json.Unmarshal([]byte(v), lp)
+	default:
+		return fmt.Errorf("cannot scan %T into LinkPreview", src)
+	}
+}
+
+// linkPreviewColumn selects the cached preview of a post's link as JSON, or
+// NULL while it is not fetched yet or could not be. Scan it into a
+// *LinkPreview.
+const linkPreviewColumn = `(
+	SELECT row_to_json(lp) FROM (
+		SELECT url, title, description, image_url, site_name
+		FROM link_previews
+		WHERE url = p.link_url AND NOT failed
+	) lp
+)`
+
+type LinkPreviewStore struct {
+	db *sql.DB
+}
+
+// IsFresh reports whether the URL was fetched, successfully or not, within
+// maxAge.
+func (s *LinkPreviewStore) IsFresh(ctx context.Context, url string, maxAge time.Duration) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM link_previews
+			WHERE url = $1 AND fetched_at > NOW() - make_interval(secs => $2)
+		);
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var fresh bool
+	err := s.db.QueryRowContext(ctx, query, url, maxAge.Seconds()).Scan(&fresh)
+
+	return fresh, err
+}
+
+// Save caches the preview of a URL. A nil or empty preview records a
+// failed fetch.
+func (s *LinkPreviewStore) Save(ctx context.Context, url string, preview *LinkPreview) error {
+	query := `
+		INSERT INTO link_previews (url, title, description, image_url, site_name, failed, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (url) DO UPDATE
+		SET title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			image_url = EXCLUDED.image_url,
+			site_name = EXCLUDED.site_name,
+			failed = EXCLUDED.failed,
+			fetched_at = EXCLUDED.fetched_at;
+	`
+	if preview == nil {
+		preview = &LinkPreview{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(
+		ctx,
+		query,
+		url,
+		preview.Title,
+		preview.Description,
+		preview.ImageURL,
+		preview.SiteName,
+		preview.Title == "" && preview.Description == "",
+	)
+
+	return err
+}
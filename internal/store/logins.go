@@ -0,0 +1,300 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	LoginEventSuccess = "login.success"
+	LoginEventLockout = "login.lockout"
+	LoginEventUnlock  = "login.unlock"
+)
+
+// LoginPolicy locks a throttle key for Lockout once it collects Threshold
+// failures, counting failures no more than Window apart. From DelayAfter
+// failures on, each attempt has to wait twice as long as the last one, up
+// to MaxDelay.
+type LoginPolicy struct {
+	Threshold  int
+	Window     time.Duration
+	Lockout    time.Duration
+	DelayAfter int
+	MaxDelay   time.Duration
+}
+
+type LoginThrottle struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+	// Locked is set by Fail when that failure caused the lockout
+	Locked bool
+}
+
+// Wait returns how long the key has to wait before its next attempt.
+func (t *LoginThrottle) Wait(policy LoginPolicy, now time.Time) time.Duration {
+	if t.LockedUntil != nil && t.LockedUntil.After(now) {
+		return t.LockedUntil.Sub(now)
+	}
+
+	if t.Failures < policy.DelayAfter || now.Sub(t.LastFailureAt) > policy.Window {
+		return 0
+	}
+
+	delay := policy.MaxDelay
+	if shift := t.Failures - policy.DelayAfter; shift < 30 {
+		delay = min(time.Second<<shift, policy.MaxDelay)
+	}
+
+	return max(t.LastFailureAt.Add(delay).Sub(now), 0)
+}
+
+type LoginEvent struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"-"`
+	Event     string `json:"event"`
+	IPAddress string `json:"ip_address"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt string `json:"created_at"`
+}
+
+type LoginStore struct {
+	db *sql.DB
+}
+
+// GetThrottle returns the failures recorded for the key, a zero throttle
+// when there are none.
+func (s *LoginStore) GetThrottle(ctx context.Context, key string) (*LoginThrottle, error) {
+	query := `SELECT failures, last_failure_at, locked_until FROM login_throttles WHERE key = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	t := &LoginThrottle{}
+	err := s.db.QueryRowContext(ctx, query, key).Scan(&t.Failures, &t.LastFailureAt, &t.LockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Fail records a failed login for the key under the policy.
+func (s *LoginStore) Fail(ctx context.Context, key string, policy LoginPolicy) (*LoginThrottle, error) {
+	t := &LoginThrottle{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// make sure the row exists so concurrent failures queue on its lock
+		query := `INSERT INTO login_throttles (key) VALUES ($1) ON CONFLICT (key) DO NOTHING;`
+		if _, err := tx.ExecContext(ctx, query, key); err != nil {
+			return err
+		}
+
+		query = `SELECT failures, last_failure_at, locked_until FROM login_throttles WHERE key = $1 FOR UPDATE;`
+		if err := tx.QueryRowContext(ctx, query, key).Scan(&t.Failures, &t.LastFailureAt, &t.LockedUntil); err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		// a served lockout or a quiet window starts the count over
+		expired := t.LockedUntil != nil && !t.LockedUntil.After(now)
+		if expired || now.Sub(t.LastFailureAt) > policy.Window {
+			t.Failures = 0
+			t.LockedUntil = nil
+		}
+
+		t.Failures++
+		t.LastFailureAt = now
+
+		if t.LockedUntil == nil && t.Failures >= policy.Threshold {
+			lockedUntil := now.Add(policy.Lockout)
+			t.LockedUntil = &lockedUntil
+			t.Locked = true
+		}
+
+		query = `
+			UPDATE login_throttles SET failures = $2, last_failure_at = $3, locked_until = $4
+			WHERE key = $1;
+		`
+		_, err := tx.ExecContext(ctx, query, key, t.Failures, t.LastFailureAt, t.LockedUntil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// PurgeExpired deletes the throttles that no longer hold anything back,
+// unlocked and without a failure in the last window, and the expired
+// unlock tokens. It returns how many rows went.
+func (s *LoginStore) PurgeExpired(ctx context.Context, window time.Duration) (int64, error) {
+	var count int64
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			DELETE FROM login_throttles
+			WHERE last_failure_at < NOW() - $1 * INTERVAL '1 second'
+				AND (locked_until IS NULL OR locked_until < NOW());
+		`
+		res, err := tx.ExecContext(ctx, query, window.Seconds())
+		if err != nil {
+			return err
+		}
+
+		throttles, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		res, err = tx.ExecContext(ctx, `DELETE FROM login_unlock_tokens WHERE expiry < NOW();`)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		count = throttles + tokens
+		return nil
+	})
+
+	return count, err
+}
+
+// Reset clears the failures of the key, after a successful login.
+func (s *LoginStore) Reset(ctx context.Context, key string) error {
+	query := `DELETE FROM login_throttles WHERE key = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, key)
+	return err
+}
+
+// CreateUnlockToken stores the token of the unlock link sent with a
+// lockout notice.
+func (s *LoginStore) CreateUnlockToken(ctx context.Context, userID int64, key, token string, exp time.Duration) error {
+	query := `
+		INSERT INTO login_unlock_tokens (token, user_id, throttle_key, expiry)
+		VALUES ($1, $2, $3, $4);
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, tokenHash(token), userID, key, time.Now().Add(exp))
+	return err
+}
+
+// Unlock lifts the lockout the token was issued for and records it as a
+// login event.
+func (s *LoginStore) Unlock(ctx context.Context, token, ipAddress, userAgent string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			DELETE FROM login_unlock_tokens
+			WHERE token = $1 AND expiry > NOW()
+			RETURNING user_id, throttle_key;
+		`
+		var userID int64
+		var key string
+
+		err := tx.QueryRowContext(ctx, query, tokenHash(token)).Scan(&userID, &key)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM login_throttles WHERE key = $1;`, key); err != nil {
+			return err
+		}
+
+		return createLoginEvent(ctx, tx, &LoginEvent{
+			UserID:    userID,
+			Event:     LoginEventUnlock,
+			IPAddress: ipAddress,
+			UserAgent: userAgent,
+		})
+	})
+}
+
+func (s *LoginStore) CreateEvent(ctx context.Context, event *LoginEvent) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		return createLoginEvent(ctx, tx, event)
+	})
+}
+
+func (s *LoginStore) GetEvents(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]LoginEvent, error) {
+	query := `
+		SELECT id, user_id, event, ip_address, user_agent, created_at
+		FROM login_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, fq.Limit, fq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []LoginEvent{}
+	for rows.Next() {
+		var e LoginEvent
+		err := rows.Scan(&e.ID, &e.UserID, &e.Event, &e.IPAddress, &e.UserAgent, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func createLoginEvent(ctx context.Context, tx *sql.Tx, event *LoginEvent) error {
+	query := `
+		INSERT INTO login_events (user_id, event, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return tx.QueryRowContext(
+		ctx,
+		query,
+		event.UserID,
+		event.Event,
+		event.IPAddress,
+		event.UserAgent,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+func tokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
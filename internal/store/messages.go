@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type Message struct {
+	ID             int64   `json:"id"`
+	ConversationID int64   `json:"conversation_id"`
+	SenderID       int64   `json:"sender_id"`
+	Content        string  `json:"content"`
+	CreatedAt      string  `json:"created_at"`
+	ReadAt         *string `json:"read_at"`
+}
+
+type MessageStore struct {
+	db *sql.DB
+}
+
+// Create sends the message, ErrBlocked when the other participant blocked
+// the sender.
+func (s *MessageStore) Create(ctx context.Context, message *Message) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO messages (conversation_id, sender_id, content)
+			SELECT c.id, $2::BIGINT, $3
+			FROM conversations c
+			WHERE c.id = $1 AND NOT EXISTS (
+				SELECT 1 FROM user_blocks ub
+				WHERE ub.blocked_id = $2::BIGINT
+					AND ub.blocker_id = CASE WHEN c.user_one_id = $2::BIGINT THEN c.user_two_id ELSE c.user_one_id END
+			)
+			RETURNING id, created_at;
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			message.ConversationID,
+			message.SenderID,
+			message.Content,
+		).Scan(
+			&message.ID,
+			&message.CreatedAt,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrBlocked
+			default:
+				return err
+			}
+		}
+
+		// bump the conversation so it sorts first in both inboxes
+		query = `UPDATE conversations SET last_message_at = $1 WHERE id = $2;`
+
+		_, err = tx.ExecContext(ctx, query, message.CreatedAt, message.ConversationID)
+		return err
+	})
+}
+
+// GetByConversationID pages backwards through a conversation, newest first.
+// A zero cq.Before starts from the latest message.
+func (s *MessageStore) GetByConversationID(ctx context.Context, conversationID int64, cq CursorQuery) ([]Message, error) {
+	query := `
+		SELECT id, conversation_id, sender_id, content, created_at, read_at
+		FROM messages
+		WHERE conversation_id = $1 AND ($2::BIGINT = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, conversationID, cq.Before, cq.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var m Message
+		err := rows.Scan(
+			&m.ID,
+			&m.ConversationID,
+			&m.SenderID,
+			&m.Content,
+			&m.CreatedAt,
+			&m.ReadAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+// MarkRead sets the read receipt on every message the reader has received
+// in the conversation.
+func (s *MessageStore) MarkRead(ctx context.Context, conversationID, readerID int64) error {
+	query := `
+		UPDATE messages SET read_at = NOW()
+		WHERE conversation_id = $1 AND sender_id <> $2 AND read_at IS NULL;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, conversationID, readerID)
+	return err
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+type MuteStore struct {
+	db *sql.DB
+}
+
+func (s *MuteStore) Mute(ctx context.Context, muterID, mutedID int64) error {
+	query := `INSERT INTO user_mutes (muter_id, muted_id) VALUES ($1, $2);`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, muterID, mutedID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *MuteStore) Unmute(ctx context.Context, muterID, mutedID int64) error {
+	query := `DELETE FROM user_mutes WHERE muter_id = $1 AND muted_id = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, muterID, mutedID)
+	return err
+}
@@ -1,8 +1,12 @@
 package store
 
 import (
+	"encoding/base64"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type PaginatedFeedQuery struct {
@@ -40,4 +44,157 @@ func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error)
 	}
 
 	return fq, nil
-}
\ No newline at end of file
+}
+
+type CursorQuery struct {
+	Limit  int   `json:"limit" validate:"gte=1,lte=50"`
+	Before int64 `json:"before" validate:"gte=0"`
+}
+
+func (cq CursorQuery) Parse(r *http.Request) (CursorQuery, error) {
+	qs := r.URL.Query()
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return cq, err
+		}
+
+		cq.Limit = l
+	}
+
+	before := qs.Get("before")
+	if before != "" {
+		b, err := strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			return cq, err
+		}
+
+		cq.Before = b
+	}
+
+	return cq, nil
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// PostCursorQuery pages through published posts, newest first. After is
+// the opaque cursor of the last post of the previous page, see PostCursor.
+type PostCursorQuery struct {
+	Limit int    `json:"limit" validate:"gte=1,lte=50"`
+	After string `json:"after" validate:"max=200"`
+
+	afterPublishedAt *time.Time
+	afterID          int64
+}
+
+func (cq PostCursorQuery) Parse(r *http.Request) (PostCursorQuery, error) {
+	qs := r.URL.Query()
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return cq, err
+		}
+
+		cq.Limit = l
+	}
+
+	after := qs.Get("after")
+	if after != "" {
+		b, err := base64.RawURLEncoding.DecodeString(after)
+		if err != nil {
+			return cq, errInvalidCursor
+		}
+
+		publishedAt, id, ok := strings.Cut(string(b), ",")
+		if !ok {
+			return cq, errInvalidCursor
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, publishedAt)
+		if err != nil {
+			return cq, errInvalidCursor
+		}
+
+		cq.afterID, err = strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return cq, errInvalidCursor
+		}
+
+		cq.After = after
+		cq.afterPublishedAt = &t
+	}
+
+	return cq, nil
+}
+
+// PostCursor returns the cursor that continues a page after the post.
+func PostCursor(p *Post) string {
+	if p.PublishedAt == nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(*p.PublishedAt + "," + strconv.FormatInt(p.ID, 10)))
+}
+
+
+type UserFilterQuery struct {
+	Role      string `json:"role" validate:"max=255"`
+	Active    *bool  `json:"active"`
+	Suspended *bool  `json:"suspended"`
+	Search    string `json:"search" validate:"max=100"`
+	Limit     int    `json:"limit" validate:"gte=1,lte=100"`
+	Offset    int    `json:"offset" validate:"gte=0"`
+}
+
+func (fq UserFilterQuery) Parse(r *http.Request) (UserFilterQuery, error) {
+	qs := r.URL.Query()
+
+	fq.Role = qs.Get("role")
+	fq.Search = qs.Get("search")
+
+	active := qs.Get("active")
+	if active != "" {
+		a, err := strconv.ParseBool(active)
+		if err != nil {
+			return fq, err
+		}
+
+		fq.Active = &a
+	}
+
+	suspended := qs.Get("suspended")
+	if suspended != "" {
+		s, err := strconv.ParseBool(suspended)
+		if err != nil {
+			return fq, err
+		}
+
+		fq.Suspended = &s
+	}
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return fq, err
+		}
+
+		fq.Limit = l
+	}
+
+	offset := qs.Get("offset")
+	if offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil {
+			return fq, err
+		}
+
+		fq.Offset = o
+	}
+
+	return fq, nil
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+const (
+	PermissionPostUpdateAny    = "post:update:any"
+	PermissionPostDeleteAny    = "post:delete:any"
+	PermissionCommentDeleteAny = "comment:delete:any"
+	PermissionReportModerate   = "report:moderate"
+	PermissionUserList         = "user:list"
+	PermissionUserRoleAssign   = "user:role:assign"
+	PermissionUserSuspend      = "user:suspend"
+	PermissionUserActivate     = "user:activate"
+	PermissionUserLogout       = "user:logout"
+	PermissionRoleManage       = "role:manage"
+	PermissionAuditRead        = "audit:read"
+)
+
+type Permission struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type PermissionStore struct {
+	db *sql.DB
+}
+
+func (s *PermissionStore) List(ctx context.Context) ([]Permission, error) {
+	query := `SELECT id, name, description FROM permissions ORDER BY name ASC;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+
+	return permissions, rows.Err()
+}
+
+// GetByRoleID returns the names of the permissions granted to the role.
+func (s *PermissionStore) GetByRoleID(ctx context.Context, roleID int64) ([]string, error) {
+	query := `
+		SELECT p.name
+		FROM permissions p
+		JOIN role_permissions rp ON rp.permission_id = p.id
+		WHERE rp.role_id = $1
+		ORDER BY p.name ASC;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, name)
+	}
+
+	return permissions, rows.Err()
+}
+
+// Grant gives the named permission to the role. Granting it twice is a
+// no-op, and unknown roles or permissions fail with ErrNotFound.
+func (s *PermissionStore) Grant(ctx context.Context, roleID int64, permission string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO role_permissions (role_id, permission_id)
+			SELECT r.id, p.id FROM roles r, permissions p
+			WHERE r.id = $1 AND p.name = $2
+			ON CONFLICT DO NOTHING
+			RETURNING role_id;
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var id int64
+		err := tx.QueryRowContext(ctx, query, roleID, permission).Scan(&id)
+		if err == sql.ErrNoRows {
+			// either nothing matched or the grant already exists
+			return s.checkGrant(ctx, tx, roleID, permission)
+		}
+
+		return err
+	})
+}
+
+func (s *PermissionStore) Revoke(ctx context.Context, roleID int64, permission string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			DELETE FROM role_permissions
+			WHERE role_id = $1 AND permission_id = (SELECT id FROM permissions WHERE name = $2);
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, roleID, permission)
+		if err != nil {
+			return err
+		}
+
+		return requireRows(res)
+	})
+}
+
+func (s *PermissionStore) checkGrant(ctx context.Context, tx *sql.Tx, roleID int64, permission string) error {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM role_permissions rp
+			JOIN permissions p ON p.id = rp.permission_id
+			WHERE rp.role_id = $1 AND p.name = $2
+		);
+	`
+	var granted bool
+	if err := tx.QueryRowContext(ctx, query, roleID, permission).Scan(&granted); err != nil {
+		return err
+	}
+
+	if !granted {
+		return ErrNotFound
+	}
+
+	return nil
+}
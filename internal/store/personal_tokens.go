@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PersonalTokenPrefix starts every personal access token, which tells them
+// apart from JWTs and makes leaked tokens easy to scan for.
+const PersonalTokenPrefix = "gsp_"
+
+// Besides these, a "<resource>:write" scope writes one resource, like
+// posts:write, and reads it too.
+const (
+	// ScopeRead reads everything the user can read
+	ScopeRead = "read"
+	// ScopeWrite writes everything the user can write
+	ScopeWrite = "write"
+)
+
+type PersonalAccessToken struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"-"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+	CreatedAt  string   `json:"created_at"`
+	// MFA is whether the login that created the token passed two-factor
+	// authentication. Roles that require it do not take tokens without.
+	MFA bool `json:"mfa"`
+}
+
+// Allows reports whether the scopes of the token cover the request. Safe
+// methods read, every other method writes.
+func (t *PersonalAccessToken) Allows(resource string, write bool) bool {
+	for _, scope := range t.Scopes {
+		switch scope {
+		case ScopeWrite:
+			return true
+		case ScopeRead:
+			if !write {
+				return true
+			}
+		case resource + ":write":
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewPersonalToken returns a random token to give to the user.
+func NewPersonalToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return PersonalTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+type PersonalTokenStore struct {
+	db *sql.DB
+}
+
+// Create stores the token hashed, the plain token cannot be read back.
+func (s *PersonalTokenStore) Create(ctx context.Context, token *PersonalAccessToken, plain string, exp time.Duration) error {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at, mfa)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, expires_at, created_at;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		token.UserID,
+		token.Name,
+		tokenHash(plain),
+		pq.Array(token.Scopes),
+		time.Now().Add(exp),
+		token.MFA,
+	).Scan(
+		&token.ID,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+}
+
+func (s *PersonalTokenStore) GetByUserID(ctx context.Context, userID int64) ([]PersonalAccessToken, error) {
+	query := `
+		SELECT id, user_id, name, scopes, expires_at, last_used_at, created_at, mfa
+		FROM personal_access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []PersonalAccessToken{}
+	for rows.Next() {
+		var t PersonalAccessToken
+		err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.Name,
+			pq.Array(&t.Scopes),
+			&t.ExpiresAt,
+			&t.LastUsedAt,
+			&t.CreatedAt,
+			&t.MFA,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// GetByToken returns the token if it is valid: not expired, and created
+// after the last force-logout of its user. It also reports whether the
+// last use is old enough to be worth recording.
+func (s *PersonalTokenStore) GetByToken(ctx context.Context, plain string) (*PersonalAccessToken, bool, error) {
+	query := `
+		SELECT t.id, t.user_id, t.name, t.scopes, t.expires_at, t.last_used_at, t.created_at, t.mfa,
+			t.last_used_at IS NULL OR t.last_used_at < NOW() - INTERVAL '1 minute'
+		FROM personal_access_tokens t
+		JOIN users u ON (u.id = t.user_id)
+		WHERE t.token_hash = $1 AND t.expires_at > NOW()
+			AND (u.tokens_valid_after IS NULL OR t.created_at >= u.tokens_valid_after);
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var t PersonalAccessToken
+	var stale bool
+
+	err := s.db.QueryRowContext(ctx, query, tokenHash(plain)).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		pq.Array(&t.Scopes),
+		&t.ExpiresAt,
+		&t.LastUsedAt,
+		&t.CreatedAt,
+		&t.MFA,
+		&stale,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, false, ErrNotFound
+		default:
+			return nil, false, err
+		}
+	}
+
+	return &t, stale, nil
+}
+
+// Touch records that the token was just used.
+func (s *PersonalTokenStore) Touch(ctx context.Context, id int64) error {
+	query := `UPDATE personal_access_tokens SET last_used_at = NOW() WHERE id = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+func (s *PersonalTokenStore) Delete(ctx context.Context, userID, id int64) error {
+	query := `DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return requireRows(res)
+}
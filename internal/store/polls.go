@@ -0,0 +1,205 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrPollClosed  = errors.New("poll is closed")
+	ErrInvalidVote = errors.New("invalid choice of poll options")
+)
+
+// Poll is attached to a post. Vote counts are only filled in once the
+// viewer has voted or the poll has closed.
+type Poll struct {
+	ID             int64        `json:"id"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	ClosesAt       string       `json:"closes_at"`
+	Closed         bool         `json:"closed"`
+	Options        []PollOption `json:"options"`
+	Voted          bool         `json:"voted"`
+	Choices        []int64      `json:"choices,omitempty"`
+	TotalVoters    *int         `json:"total_voters,omitempty"`
+}
+
+type PollOption struct {
+	ID    int64  `json:"id"`
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+type PollStore struct {
+	db *sql.DB
+}
+
+// GetByPostIDs loads the polls of a page of posts in one query, keyed by
+// post ID, as seen by the viewer.
+func (s *PollStore) GetByPostIDs(ctx context.Context, postIDs []int64, viewerID int64) (map[int64]*Poll, error) {
+	query := `
+		SELECT
+			pl.post_id, pl.id, pl.multiple_choice, pl.closes_at, pl.closes_at <= NOW(),
+			(SELECT COUNT(*) FROM poll_ballots b WHERE b.poll_id = pl.id),
+			EXISTS (SELECT 1 FROM poll_ballots b WHERE b.poll_id = pl.id AND b.user_id = $2),
+			o.id, o.text,
+			(SELECT COUNT(*) FROM poll_ballot_options bo WHERE bo.option_id = o.id),
+			EXISTS (SELECT 1 FROM poll_ballot_options bo WHERE bo.option_id = o.id AND bo.user_id = $2)
+		FROM polls pl
+		JOIN poll_options o ON o.poll_id = pl.id
+		WHERE pl.post_id = ANY($1)
+		ORDER BY pl.id, o.position;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(postIDs), viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	polls := map[int64]*Poll{}
+	for rows.Next() {
+		var (
+			postID      int64
+			p           Poll
+			totalVoters int
+			o           PollOption
+			votes       int
+			chosen      bool
+		)
+
+		err := rows.Scan(
+			&postID,
+			&p.ID,
+			&p.MultipleChoice,
+			&p.ClosesAt,
+			&p.Closed,
+			&totalVoters,
+			&p.Voted,
+			&o.ID,
+			&o.Text,
+			&votes,
+			&chosen,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		poll, ok := polls[postID]
+		if !ok {
+			poll = &p
+			poll.Options = []PollOption{}
+			if poll.Voted || poll.Closed {
+				poll.TotalVoters = &totalVoters
+			}
+			polls[postID] = poll
+		}
+
+		// results stay hidden until the viewer voted or the poll closed
+		if poll.Voted || poll.Closed {
+			o.Votes = &votes
+		}
+
+		if chosen {
+			poll.Choices = append(poll.Choices, o.ID)
+		}
+
+		poll.Options = append(poll.Options, o)
+	}
+
+	return polls, rows.Err()
+}
+
+// Vote records the user's ballot on the poll of a post. A second ballot
+// fails with ErrConflict, a closed poll with ErrPollClosed, and options
+// outside the poll, or several on a single-choice poll, with
+// ErrInvalidVote.
+func (s *PollStore) Vote(ctx context.Context, postID, userID int64, optionIDs []int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `SELECT id, multiple_choice, closes_at <= NOW() FROM polls WHERE post_id = $1;`
+
+		var (
+			pollID         int64
+			multipleChoice bool
+			closed         bool
+		)
+
+		err := tx.QueryRowContext(ctx, query, postID).Scan(&pollID, &multipleChoice, &closed)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		if closed {
+			return ErrPollClosed
+		}
+
+		if len(optionIDs) == 0 || (!multipleChoice && len(optionIDs) > 1) {
+			return ErrInvalidVote
+		}
+
+		query = `INSERT INTO poll_ballots (poll_id, user_id) VALUES ($1, $2);`
+
+		if _, err := tx.ExecContext(ctx, query, pollID, userID); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+
+		query = `
+			INSERT INTO poll_ballot_options (poll_id, user_id, option_id)
+			SELECT $1, $2, o.id FROM poll_options o
+			WHERE o.poll_id = $1 AND o.id = ANY($3);
+		`
+		res, err := tx.ExecContext(ctx, query, pollID, userID, pq.Array(optionIDs))
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if int(rows) != len(optionIDs) {
+			return ErrInvalidVote
+		}
+
+		return nil
+	})
+}
+
+// createPoll inserts the poll of a new post, in the post's transaction.
+func createPoll(ctx context.Context, tx *sql.Tx, postID int64, poll *Poll) error {
+	query := `
+		INSERT INTO polls (post_id, multiple_choice, closes_at)
+		VALUES ($1, $2, $3) RETURNING id, closes_at;
+	`
+	err := tx.QueryRowContext(ctx, query, postID, poll.MultipleChoice, poll.ClosesAt).Scan(&poll.ID, &poll.ClosesAt)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO poll_options (poll_id, position, text) VALUES ($1, $2, $3) RETURNING id;`
+
+	for i := range poll.Options {
+		err := tx.QueryRowContext(ctx, query, poll.ID, i, poll.Options[i].Text).Scan(&poll.Options[i].ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
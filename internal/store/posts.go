@@ -8,41 +8,128 @@ import (
 	"github.com/lib/pq"
 )
 
+const (
+	PostStatusDraft     = "draft"
+	PostStatusScheduled = "scheduled"
+	PostStatusPublished = "published"
+)
+
 type Post struct {
-	ID        int64     `json:"id"`
-	Content   string    `json:"content"`
-	Title     string    `json:"title"`
-	UserID    int64     `json:"user_id"`
-	Tags      []string  `json:"tags"`
-	CreatedAt string    `json:"created_at"`
-	UpdatedAt string    `json:"updated_at"`
-	Version   int       `json:"version"`
-	Comments  []Comment `json:"comments"`
-	User      User      `json:"user"`
+	ID          int64     `json:"id"`
+	Content     string    `json:"content"`
+	Title       string    `json:"title"`
+	UserID      int64     `json:"user_id"`
+	Tags        []string  `json:"tags"`
+	Status      string    `json:"status"`
+	PublishAt   *string   `json:"publish_at,omitempty"`
+	PublishedAt *string   `json:"published_at,omitempty"`
+	CreatedAt   string    `json:"created_at"`
+	UpdatedAt   string    `json:"updated_at"`
+	Version     int       `json:"version"`
+	Edited      bool      `json:"edited"`
+	Comments    []Comment `json:"comments"`
+	User        User      `json:"user"`
+
+	QuotedPostID *int64      `json:"quoted_post_id,omitempty"`
+	IsQuote      bool        `json:"is_quote"`
+	QuotedPost   *QuotedPost `json:"quoted_post,omitempty"`
+
+	Poll *Poll `json:"poll,omitempty"`
+
+	// LinkURL is the first link in the content, its preview is fetched in
+	// the background and shows up once it is ready
+	LinkURL     *string      `json:"link_url,omitempty"`
+	LinkPreview *LinkPreview `json:"link_preview,omitempty"`
+}
+
+// QuotedPost is the post a quote refers to. It is a tombstone with only
+// Unavailable set when the original was deleted or the viewer may not see
+// it.
+type QuotedPost struct {
+	ID          int64  `json:"id,omitempty"`
+	UserID      int64  `json:"user_id,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Content     string `json:"content,omitempty"`
+	Unavailable bool   `json:"unavailable"`
 }
 
+// postEdited is true for posts changed after they were published.
+const postEdited = `(p.published_at IS NOT NULL AND p.updated_at > p.published_at)`
+
 type PostWithMetadata struct {
 	Post
-	CommentCount int `json:"comments_count"`
+	CommentCount int   `json:"comments_count"`
+	RepostCount  int   `json:"reposts_count"`
+	RepostedBy   *User `json:"reposted_by,omitempty"`
+}
+
+type PostRevision struct {
+	Version   int    `json:"version"`
+	Title     string `json:"title"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
 }
 
 type PostStore struct {
 	db *sql.DB
 }
 
+// GetUserFeed returns the user's own published posts and reposts, those
+// of the users they follow and posts with tags they follow, leaving out
+// authors hidden by a block or mute. Each post shows up once, at its
+// latest activity.
 func (s *PostStore) GetUserFeed(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]PostWithMetadata, error) {
 	query := `
+		WITH items AS (
+			SELECT p.id AS post_id, NULL::BIGINT AS reposted_by, p.published_at AS activity_at
+			FROM posts p
+			WHERE p.status = 'published' AND (
+				p.user_id = $1
+				OR EXISTS (SELECT 1 FROM followers f WHERE f.user_id = p.user_id AND f.follower_id = $1)
+			)
+			UNION ALL
+			SELECT r.post_id, r.user_id, r.created_at
+			FROM reposts r
+			WHERE (
+				r.user_id = $1
+				OR EXISTS (SELECT 1 FROM followers f WHERE f.user_id = r.user_id AND f.follower_id = $1)
+			)
+			AND ` + relationshipFilter("r.user_id", "$1") + `
+			UNION ALL
+			SELECT p.id, NULL::BIGINT, p.published_at
+			FROM posts p
+			WHERE p.status = 'published'
+			AND p.tags && ARRAY(SELECT tf.tag FROM tag_follows tf WHERE tf.user_id = $1)::VARCHAR(100)[]
+		),
+		latest AS (
+			SELECT DISTINCT ON (post_id) post_id, reposted_by, activity_at
+			FROM items
+			ORDER BY post_id, activity_at DESC
+		)
 		SELECT
-			p.id, p.user_id, p.title, p.content, p.created_at, p.version, p.tags,
+			p.id, p.user_id, p.title, p.content, p.status, p.published_at, p.created_at, p.updated_at,
+			p.version, ` + postEdited + `, p.tags, p.is_quote, p.link_url, ` + linkPreviewColumn + `,
 			u.username,
-			COUNT(c.id) AS comments_count
-		FROM posts p
-		LEFT JOIN comments c ON c.post_id = p.id
-		LEFT JOIN users u ON p.user_id = u.id
-		JOIN followers f ON f.follower_id = p.user_id OR p.user_id = $1
-		WHERE f.user_id = $1 OR p.user_id = $1
-		GROUP BY p.id, u.username
-		ORDER BY p.created_at ` + fq.Sort + `
+			(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments_count,
+			(SELECT COUNT(*) FROM reposts rc WHERE rc.post_id = p.id) AS reposts_count,
+			ru.id, ru.username,
+			q.id, q.user_id, qu.username, q.title, q.content
+		FROM latest i
+		JOIN posts p ON p.id = i.post_id
+		JOIN users u ON p.user_id = u.id
+		LEFT JOIN users ru ON ru.id = i.reposted_by
+		LEFT JOIN posts q ON q.id = p.quoted_post_id
+			AND q.status = 'published'
+			AND NOT q.is_hidden
+			AND ` + relationshipFilter("q.user_id", "$1") + `
+			AND ` + privacyFilter("q.user_id", "$1") + `
+		LEFT JOIN users qu ON qu.id = q.user_id
+		WHERE p.status = 'published'
+		AND NOT p.is_hidden
+		AND ` + relationshipFilter("p.user_id", "$1") + `
+		AND ` + privacyFilter("p.user_id", "$1") + `
+		ORDER BY i.activity_at ` + fq.Sort + `
 		LIMIT $2 OFFSET $3;
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -57,56 +144,124 @@ func (s *PostStore) GetUserFeed(ctx context.Context, userID int64, fq PaginatedF
 	var feed []PostWithMetadata
 	for rows.Next() {
 		var p PostWithMetadata
+		var (
+			reposterID         *int64
+			reposterName       *string
+			quotedID, quotedBy *int64
+			quotedAuthor       *string
+			quotedTitle        *string
+			quotedContent      *string
+		)
 
 		err := rows.Scan(
 			&p.ID,
 			&p.UserID,
 			&p.Title,
 			&p.Content,
+			&p.Status,
+			&p.PublishedAt,
 			&p.CreatedAt,
+			&p.UpdatedAt,
 			&p.Version,
+			&p.Edited,
 			pq.Array(&p.Tags),
+			&p.IsQuote,
+			&p.LinkURL,
+			&p.LinkPreview,
 			&p.User.Username,
 			&p.CommentCount,
+			&p.RepostCount,
+			&reposterID,
+			&reposterName,
+			&quotedID,
+			&quotedBy,
+			&quotedAuthor,
+			&quotedTitle,
+			&quotedContent,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		if reposterID != nil {
+			p.RepostedBy = &User{ID: *reposterID, Username: *reposterName}
+		}
+
+		if p.IsQuote {
+			p.QuotedPostID = quotedID
+			p.QuotedPost = &QuotedPost{Unavailable: true}
+
+			if quotedID != nil {
+				p.QuotedPost = &QuotedPost{
+					ID:       *quotedID,
+					UserID:   *quotedBy,
+					Username: *quotedAuthor,
+					Title:    *quotedTitle,
+					Content:  *quotedContent,
+				}
+			}
+		}
+
 		feed = append(feed, p)
 	}
-	return feed, nil
+
+	return feed, rows.Err()
 }
 
 func (s *PostStore) Create(ctx context.Context, p *Post) error {
 	query := `
-		INSERT INTO posts (content, title, user_id, tags)
-		VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at;
+		INSERT INTO posts (content, title, user_id, tags, status, publish_at, published_at, quoted_post_id, is_quote, link_url)
+		VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $5 = 'published' THEN NOW() END, $7, $7::BIGINT IS NOT NULL, $8)
+		RETURNING id, published_at, created_at, updated_at;
 	`
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		p.Content,
-		p.Title,
-		p.UserID,
-		pq.Array(p.Tags),
-	).Scan(
-		&p.ID,
-		&p.CreatedAt,
-		&p.UpdatedAt,
-	)
+	if p.Status == "" {
+		p.Status = PostStatusPublished
+	}
+
+	tags, err := normalizeTags(p.Tags)
 	if err != nil {
 		return err
 	}
+	p.Tags = tags
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			p.Content,
+			p.Title,
+			p.UserID,
+			pq.Array(p.Tags),
+			p.Status,
+			p.PublishAt,
+			p.QuotedPostID,
+			p.LinkURL,
+		).Scan(
+			&p.ID,
+			&p.PublishedAt,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		p.IsQuote = p.QuotedPostID != nil
+
+		if p.Poll != nil {
+			return createPoll(ctx, tx, p.ID, p.Poll)
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 	query := `
-		SELECT id, user_id, title, content, tags, created_at, updated_at, version
-		FROM posts
-		WHERE id = $1;
+		SELECT p.id, p.user_id, p.title, p.content, p.tags, p.status, p.publish_at, p.published_at,
+			p.created_at, p.updated_at, p.version, ` + postEdited + `, p.quoted_post_id, p.is_quote,
+			p.link_url, ` + linkPreviewColumn + `
+		FROM posts p
+		WHERE p.id = $1 AND NOT p.is_hidden;
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -119,9 +274,17 @@ func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 		&post.Title,
 		&post.Content,
 		pq.Array(&post.Tags),
+		&post.Status,
+		&post.PublishAt,
+		&post.PublishedAt,
 		&post.CreatedAt,
 		&post.UpdatedAt,
 		&post.Version,
+		&post.Edited,
+		&post.QuotedPostID,
+		&post.IsQuote,
+		&post.LinkURL,
+		&post.LinkPreview,
 	)
 	if err != nil {
 		switch {
@@ -135,52 +298,221 @@ func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 	return &post, nil
 }
 
+// Update saves the post if it is still at post.Version, keeping the text it
+// replaces as a revision. It fails with ErrEditConflict when the post has
+// moved on to another version.
 func (s *PostStore) Update(ctx context.Context, post *Post) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// the lock makes concurrent updates of the same version wait here
+		// and then miss, instead of racing to save the same revision
+		query := `
+			SELECT title, content, updated_at FROM posts
+			WHERE id = $1 AND version = $2 AND NOT is_hidden
+			FOR UPDATE;
+		`
+		var old PostRevision
+		err := tx.QueryRowContext(ctx, query, post.ID, post.Version).Scan(&old.Title, &old.Content, &old.CreatedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return s.versionConflict(ctx, tx, post.ID)
+			default:
+				return err
+			}
+		}
+
+		// publishing or rescheduling alone does not make a revision
+		if old.Title != post.Title || old.Content != post.Content {
+			query = `
+				INSERT INTO post_revisions (post_id, version, title, content, created_at)
+				VALUES ($1, $2, $3, $4, $5);
+			`
+			_, err := tx.ExecContext(ctx, query, post.ID, post.Version, old.Title, old.Content, old.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		query = `
+			UPDATE posts p
+			SET title = $1, content = $2, status = $3, publish_at = $4,
+				published_at = CASE WHEN $3 = 'published' THEN COALESCE(p.published_at, NOW()) END,
+				link_url = $6, version = version + 1, updated_at = NOW()
+			WHERE p.id = $5
+			RETURNING p.version, p.published_at, p.updated_at, ` + postEdited + `;
+		`
+		return tx.QueryRowContext(
+			ctx,
+			query,
+			post.Title,
+			post.Content,
+			post.Status,
+			post.PublishAt,
+			post.ID,
+			post.LinkURL,
+		).Scan(
+			&post.Version,
+			&post.PublishedAt,
+			&post.UpdatedAt,
+			&post.Edited,
+		)
+	})
+}
+
+// GetRevisions returns the earlier versions of a post, oldest first.
+func (s *PostStore) GetRevisions(ctx context.Context, postID int64) ([]PostRevision, error) {
 	query := `
-		UPDATE posts 
-		SET title = $1, content = $2, version = version + 1
-		WHERE id = $3 AND version = $4
-		RETURNING version;
+		SELECT version, title, content, created_at
+		FROM post_revisions
+		WHERE post_id = $1
+		ORDER BY version ASC;
 	`
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		post.Title,
-		post.Content,
-		post.ID,
-		post.Version,
-	).Scan(&post.Version)
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, postID)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrNotFound
-		default:
-			return err
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []PostRevision{}
+	for rows.Next() {
+		var r PostRevision
+		if err := rows.Scan(&r.Version, &r.Title, &r.Content, &r.CreatedAt); err != nil {
+			return nil, err
 		}
+		revisions = append(revisions, r)
 	}
 
-	return nil
+	return revisions, rows.Err()
 }
 
-func (s *PostStore) Delete(ctx context.Context, postID int64) error {
-	query := `DELETE FROM posts WHERE id = $1;`
+// Delete removes the post if it is still at version, failing with
+// ErrEditConflict otherwise.
+func (s *PostStore) Delete(ctx context.Context, postID int64, version int) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM posts WHERE id = $1 AND version = $2;`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
 
+		res, err := tx.ExecContext(ctx, query, postID, version)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return s.versionConflict(ctx, tx, postID)
+		}
+
+		return nil
+	})
+}
+
+// GetDrafts returns the user's drafts and scheduled posts, most recently
+// changed first.
+func (s *PostStore) GetDrafts(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]Post, error) {
+	query := `
+		SELECT id, user_id, title, content, tags, status, publish_at, created_at, updated_at, version
+		FROM posts
+		WHERE user_id = $1 AND status <> 'published' AND NOT is_hidden
+		ORDER BY updated_at ` + fq.Sort + `
+		LIMIT $2 OFFSET $3;
+	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	res, err := s.db.ExecContext(ctx, query, postID)
+	rows, err := s.db.QueryContext(ctx, query, userID, fq.Limit, fq.Offset)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	rows, err := res.RowsAffected()
+	drafts := []Post{}
+	for rows.Next() {
+		var p Post
+		err := rows.Scan(
+			&p.ID,
+			&p.UserID,
+			&p.Title,
+			&p.Content,
+			pq.Array(&p.Tags),
+			&p.Status,
+			&p.PublishAt,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, p)
+	}
+
+	return drafts, rows.Err()
+}
+
+// PublishDue publishes up to limit scheduled posts whose time has come and
+// returns the ids it published. Rows locked by another replica's run are
+// skipped, so each post is published exactly once.
+func (s *PostStore) PublishDue(ctx context.Context, limit int) ([]int64, error) {
+	query := `
+		WITH due AS (
+			SELECT id FROM posts
+			WHERE status = 'scheduled' AND publish_at <= NOW()
+			ORDER BY publish_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE posts p
+		SET status = 'published', published_at = p.publish_at
+		FROM due
+		WHERE p.id = due.id
+		RETURNING p.id;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, limit)
 	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// versionConflict tells a missing post from one at another version when a
+// versioned write matched no rows.
+func (s *PostStore) versionConflict(ctx context.Context, tx *sql.Tx, postID int64) error {
+	query := `SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND NOT is_hidden);`
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, query, postID).Scan(&exists); err != nil {
 		return err
 	}
 
-	if rows == 0 {
+	if !exists {
 		return ErrNotFound
 	}
 
-	return nil
+	return ErrEditConflict
 }
@@ -0,0 +1,477 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ReportTargetPost    = "post"
+	ReportTargetComment = "comment"
+	ReportTargetUser    = "user"
+
+	ReportStatusOpen      = "open"
+	ReportStatusClaimed   = "claimed"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+
+	ResolutionNone        = "none"
+	ResolutionHideContent = "hide_content"
+	ResolutionSuspendUser = "suspend_user"
+)
+
+var (
+	ErrInvalidResolution = errors.New("resolution does not apply to the reported target")
+	ErrProtectedUser     = errors.New("user can suspend users or manage roles")
+)
+
+type Report struct {
+	ID         int64          `json:"id"`
+	ReporterID int64          `json:"reporter_id"`
+	TargetType string         `json:"target_type"`
+	TargetID   int64          `json:"target_id"`
+	Reason     string         `json:"reason"`
+	Details    string         `json:"details"`
+	Status     string         `json:"status"`
+	AssigneeID *int64         `json:"assignee_id"`
+	Resolution *string        `json:"resolution"`
+	CreatedAt  string         `json:"created_at"`
+	UpdatedAt  string         `json:"updated_at"`
+	Actions    []ReportAction `json:"actions,omitempty"`
+}
+
+// ResolvedTarget is what a resolution changed, ids are zero when it did not
+// touch them. PostID is the hidden post, or the post of the hidden comment.
+type ResolvedTarget struct {
+	PostID int64
+	UserID int64
+}
+
+// ReportAction records who did what to a report, and when.
+type ReportAction struct {
+	ID        int64  `json:"id"`
+	ReportID  int64  `json:"report_id"`
+	ActorID   int64  `json:"actor_id"`
+	Action    string `json:"action"`
+	Note      string `json:"note"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ReportQuery struct {
+	Status string `json:"status" validate:"oneof=open claimed resolved dismissed"`
+	Limit  int    `json:"limit" validate:"gte=1,lte=50"`
+	Offset int    `json:"offset" validate:"gte=0"`
+}
+
+func (rq ReportQuery) Parse(r *http.Request) (ReportQuery, error) {
+	qs := r.URL.Query()
+
+	status := qs.Get("status")
+	if status != "" {
+		rq.Status = status
+	}
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return rq, err
+		}
+
+		rq.Limit = l
+	}
+
+	offset := qs.Get("offset")
+	if offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil {
+			return rq, err
+		}
+
+		rq.Offset = o
+	}
+
+	return rq, nil
+}
+
+type ReportStore struct {
+	db *sql.DB
+}
+
+func (s *ReportStore) Create(ctx context.Context, report *Report) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		exists, err := s.targetExists(ctx, tx, report.TargetType, report.TargetID)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNotFound
+		}
+
+		query := `
+			INSERT INTO reports (reporter_id, target_type, target_id, reason, details)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, status, created_at, updated_at;
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err = tx.QueryRowContext(
+			ctx,
+			query,
+			report.ReporterID,
+			report.TargetType,
+			report.TargetID,
+			report.Reason,
+			report.Details,
+		).Scan(
+			&report.ID,
+			&report.Status,
+			&report.CreatedAt,
+			&report.UpdatedAt,
+		)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+
+		return s.createAction(ctx, tx, report.ID, report.ReporterID, "created", "")
+	})
+}
+
+func (s *ReportStore) GetByID(ctx context.Context, id int64) (*Report, error) {
+	query := `
+		SELECT id, reporter_id, target_type, target_id, reason, details, status,
+			assignee_id, resolution, created_at, updated_at
+		FROM reports
+		WHERE id = $1;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	report := &Report{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&report.ID,
+		&report.ReporterID,
+		&report.TargetType,
+		&report.TargetID,
+		&report.Reason,
+		&report.Details,
+		&report.Status,
+		&report.AssigneeID,
+		&report.Resolution,
+		&report.CreatedAt,
+		&report.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	actions, err := s.getActions(ctx, report.ID)
+	if err != nil {
+		return nil, err
+	}
+	report.Actions = actions
+
+	return report, nil
+}
+
+// List returns the moderation queue for one status, oldest first.
+func (s *ReportStore) List(ctx context.Context, rq ReportQuery) ([]Report, error) {
+	query := `
+		SELECT id, reporter_id, target_type, target_id, reason, details, status,
+			assignee_id, resolution, created_at, updated_at
+		FROM reports
+		WHERE status = $1
+		ORDER BY created_at ASC, id ASC
+		LIMIT $2 OFFSET $3;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, rq.Status, rq.Limit, rq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var r Report
+		err := rows.Scan(
+			&r.ID,
+			&r.ReporterID,
+			&r.TargetType,
+			&r.TargetID,
+			&r.Reason,
+			&r.Details,
+			&r.Status,
+			&r.AssigneeID,
+			&r.Resolution,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+
+	return reports, rows.Err()
+}
+
+// Claim assigns an open report to the moderator. It fails with ErrConflict
+// when someone else got there first.
+func (s *ReportStore) Claim(ctx context.Context, reportID, moderatorID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE reports SET status = 'claimed', assignee_id = $1, updated_at = NOW()
+			WHERE id = $2 AND status = 'open';
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, moderatorID, reportID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return s.missingOrTaken(ctx, tx, reportID)
+		}
+
+		return s.createAction(ctx, tx, reportID, moderatorID, "claimed", "")
+	})
+}
+
+// Resolve closes the report and applies the resolution to its target:
+// hiding the post or comment, or suspending the user or author.
+func (s *ReportStore) Resolve(ctx context.Context, reportID, moderatorID int64, resolution, note string) (*ResolvedTarget, error) {
+	target := &ResolvedTarget{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		report, err := s.closeReport(ctx, tx, reportID, moderatorID, ReportStatusResolved, resolution)
+		if err != nil {
+			return err
+		}
+
+		switch resolution {
+		case ResolutionHideContent:
+			err = s.hideTarget(ctx, tx, report, target)
+		case ResolutionSuspendUser:
+			err = s.suspendTarget(ctx, tx, report, target)
+		}
+		if err != nil {
+			return err
+		}
+
+		return s.createAction(ctx, tx, reportID, moderatorID, "resolved:"+resolution, note)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+func (s *ReportStore) Dismiss(ctx context.Context, reportID, moderatorID int64, note string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if _, err := s.closeReport(ctx, tx, reportID, moderatorID, ReportStatusDismissed, ResolutionNone); err != nil {
+			return err
+		}
+
+		return s.createAction(ctx, tx, reportID, moderatorID, "dismissed", note)
+	})
+}
+
+// ----------	Private Method	-----------
+
+// closeReport moves a report that is open, or claimed by the moderator, to
+// its final status.
+func (s *ReportStore) closeReport(ctx context.Context, tx *sql.Tx, reportID, moderatorID int64, status, resolution string) (*Report, error) {
+	query := `
+		UPDATE reports SET status = $1, resolution = $2, assignee_id = $3, updated_at = NOW()
+		WHERE id = $4 AND (status = 'open' OR (status = 'claimed' AND assignee_id = $3))
+		RETURNING target_type, target_id;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	report := &Report{ID: reportID}
+	err := tx.QueryRowContext(ctx, query, status, resolution, moderatorID, reportID).Scan(
+		&report.TargetType,
+		&report.TargetID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, s.missingOrTaken(ctx, tx, reportID)
+		default:
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// missingOrTaken tells a report that does not exist apart from one that is
+// no longer available to the moderator.
+func (s *ReportStore) missingOrTaken(ctx context.Context, tx *sql.Tx, reportID int64) error {
+	query := `SELECT EXISTS (SELECT 1 FROM reports WHERE id = $1);`
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, query, reportID).Scan(&exists); err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrNotFound
+	}
+
+	return ErrConflict
+}
+
+func (s *ReportStore) hideTarget(ctx context.Context, tx *sql.Tx, report *Report, target *ResolvedTarget) error {
+	var query string
+	switch report.TargetType {
+	case ReportTargetPost:
+		query = `UPDATE posts SET is_hidden = true WHERE id = $1 RETURNING id;`
+	case ReportTargetComment:
+		query = `UPDATE comments SET is_hidden = true WHERE id = $1 RETURNING post_id;`
+	default:
+		return ErrInvalidResolution
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	// a target deleted since it was reported has nothing left to hide
+	err := tx.QueryRowContext(ctx, query, report.TargetID).Scan(&target.PostID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+// suspendTarget suspends the reported user, or the author of the reported
+// post or comment. It fails with ErrProtectedUser when the user's role can
+// suspend users or manage roles, moderators do not suspend each other.
+func (s *ReportStore) suspendTarget(ctx context.Context, tx *sql.Tx, report *Report, target *ResolvedTarget) error {
+	var query string
+	switch report.TargetType {
+	case ReportTargetPost:
+		query = `SELECT user_id FROM posts WHERE id = $1;`
+	case ReportTargetComment:
+		query = `SELECT user_id FROM comments WHERE id = $1;`
+	default:
+		query = `SELECT id FROM users WHERE id = $1;`
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var userID int64
+	err := tx.QueryRowContext(ctx, query, report.TargetID).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE users u SET is_suspended = true
+		WHERE u.id = $1 AND NOT EXISTS (
+			SELECT 1 FROM role_permissions rp
+			JOIN permissions p ON p.id = rp.permission_id
+			WHERE rp.role_id = u.role_id AND p.name IN ($2, $3)
+		)
+		RETURNING u.id;
+	`
+
+	err = tx.QueryRowContext(ctx, query, userID, PermissionUserSuspend, PermissionRoleManage).Scan(&target.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProtectedUser
+	}
+	return err
+}
+
+func (s *ReportStore) targetExists(ctx context.Context, tx *sql.Tx, targetType string, targetID int64) (bool, error) {
+	var query string
+	switch targetType {
+	case ReportTargetPost:
+		query = `SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1);`
+	case ReportTargetComment:
+		query = `SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1);`
+	default:
+		query = `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1);`
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var exists bool
+	err := tx.QueryRowContext(ctx, query, targetID).Scan(&exists)
+	return exists, err
+}
+
+func (s *ReportStore) createAction(ctx context.Context, tx *sql.Tx, reportID, actorID int64, action, note string) error {
+	query := `INSERT INTO report_actions (report_id, actor_id, action, note) VALUES ($1, $2, $3, $4);`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, reportID, actorID, action, note)
+	return err
+}
+
+func (s *ReportStore) getActions(ctx context.Context, reportID int64) ([]ReportAction, error) {
+	query := `
+		SELECT id, report_id, actor_id, action, note, created_at
+		FROM report_actions
+		WHERE report_id = $1
+		ORDER BY id ASC;
+	`
+	rows, err := s.db.QueryContext(ctx, query, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []ReportAction{}
+	for rows.Next() {
+		var a ReportAction
+		err := rows.Scan(
+			&a.ID,
+			&a.ReportID,
+			&a.ActorID,
+			&a.Action,
+			&a.Note,
+			&a.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+
+	return actions, rows.Err()
+}
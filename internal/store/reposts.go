@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+type RepostStore struct {
+	db *sql.DB
+}
+
+func (s *RepostStore) Create(ctx context.Context, userID, postID int64) error {
+	query := `INSERT INTO reposts (user_id, post_id) VALUES ($1, $2);`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID, postID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *RepostStore) Delete(ctx context.Context, userID, postID int64) error {
+	query := `DELETE FROM reposts WHERE user_id = $1 AND post_id = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID, postID)
+	return err
+}
@@ -3,6 +3,9 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
 )
 
 type Role struct {
@@ -10,6 +13,9 @@ type Role struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Level       int    `json:"level"`
+	// Require2FA denies the users of the role everything but setting up
+	// two-factor authentication until they sign in with it
+	Require2FA bool `json:"require_2fa"`
 }
 
 type RoleStore struct {
@@ -18,7 +24,7 @@ type RoleStore struct {
 
 func (s *RoleStore) GetByName(ctx context.Context, roleName string) (*Role, error) {
 	query := `
-		SELECT id, name, description, level
+		SELECT id, name, description, level, require_2fa
 		FROM roles WHERE name = $1;
 	`
 	role := &Role{}
@@ -28,6 +34,7 @@ func (s *RoleStore) GetByName(ctx context.Context, roleName string) (*Role, erro
 		&role.Name,
 		&role.Description,
 		&role.Level,
+		&role.Require2FA,
 	)
 	if err != nil {
 		return nil, err
@@ -35,3 +42,129 @@ func (s *RoleStore) GetByName(ctx context.Context, roleName string) (*Role, erro
 
 	return role, nil
 }
+
+func (s *RoleStore) GetByID(ctx context.Context, id int64) (*Role, error) {
+	query := `
+		SELECT id, name, description, level, require_2fa
+		FROM roles WHERE id = $1;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	role := &Role{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.Level,
+		&role.Require2FA,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return role, nil
+}
+
+func (s *RoleStore) List(ctx context.Context) ([]Role, error) {
+	query := `
+		SELECT id, name, description, level, require_2fa
+		FROM roles ORDER BY level ASC, id ASC;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var r Role
+		err := rows.Scan(
+			&r.ID,
+			&r.Name,
+			&r.Description,
+			&r.Level,
+			&r.Require2FA,
+		)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+
+	return roles, rows.Err()
+}
+
+func (s *RoleStore) Create(ctx context.Context, role *Role) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO roles (name, description, level, require_2fa)
+			VALUES ($1, $2, $3, $4) RETURNING id;
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, role.Name, role.Description, role.Level, role.Require2FA).Scan(&role.ID)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+
+		// the role has no id until now
+		if event := auditEventFromContext(ctx); event != nil {
+			event.TargetID = role.ID
+		}
+
+		return nil
+	})
+}
+
+func (s *RoleStore) Update(ctx context.Context, role *Role) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE roles SET name = $1, description = $2, level = $3, require_2fa = $4 WHERE id = $5;`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, role.Name, role.Description, role.Level, role.Require2FA, role.ID)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+
+		return requireRows(res)
+	})
+}
+
+// Delete removes a role. Roles that still have users fail with ErrConflict.
+func (s *RoleStore) Delete(ctx context.Context, id int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM roles WHERE id = $1;`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+				return ErrConflict
+			}
+			return err
+		}
+
+		return requireRows(res)
+	})
+}
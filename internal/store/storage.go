@@ -10,6 +10,8 @@ import (
 var (
 	ErrNotFound = errors.New("resource not found")
 	ErrConflict = errors.New("resource already exists")
+	// ErrEditConflict means the row changed since the caller last read it.
+	ErrEditConflict = errors.New("resource was modified by another request")
 
 	QueryTimeoutDuration = time.Second * 5
 )
@@ -19,27 +21,153 @@ type Storage struct {
 		GetByID(context.Context, int64) (*Post, error)
 		Create(context.Context, *Post) error
 		Update(context.Context, *Post) error
-		Delete(context.Context, int64) error
+		Delete(ctx context.Context, postID int64, version int) error
 		GetUserFeed(context.Context, int64, PaginatedFeedQuery) ([]PostWithMetadata, error)
+		GetRevisions(ctx context.Context, postID int64) ([]PostRevision, error)
+		GetDrafts(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]Post, error)
+		PublishDue(ctx context.Context, limit int) ([]int64, error)
 	}
 	Users interface {
 		GetByID(context.Context, int64) (*User, error)
 		GetByEmail(context.Context, string) (*User, error)
 		Create(context.Context, *sql.Tx, *User) error
 		CreateAndInvite(ctx context.Context, user *User, token string, exp time.Duration) error
-		Activate(ctx context.Context, token string) error
+		Activate(ctx context.Context, token string) (int64, error)
 		Delete(ctx context.Context, userID int64) error
+		UpdateSettings(ctx context.Context, user *User) error
+		List(ctx context.Context, fq UserFilterQuery) ([]User, error)
+		SetRole(ctx context.Context, userID int64, roleName string) error
+		SetSuspended(ctx context.Context, userID int64, suspended bool) error
+		ForceActivate(ctx context.Context, userID int64) error
+		RevokeTokens(ctx context.Context, userID int64) error
 	}
 	Comments interface {
 		Create(context.Context, *Comment) error
-		GetByPostID(context.Context, int64) ([]Comment, error)
+		GetByPostID(ctx context.Context, postID, viewerID int64) ([]Comment, error)
+		GetByID(ctx context.Context, postID, commentID int64) (*Comment, error)
+		Delete(ctx context.Context, commentID int64) error
 	}
 	Followers interface {
 		Follow(ctx context.Context, followerID, userID int64) error
 		Unfollow(ctx context.Context, followerID, userID int64) error
+		IsMutual(ctx context.Context, userID, otherID int64) (bool, error)
+		Request(ctx context.Context, requesterID, userID int64) error
+		GetRequests(ctx context.Context, userID int64) ([]FollowRequest, error)
+		ApproveRequest(ctx context.Context, userID, requesterID int64) error
+		RejectRequest(ctx context.Context, userID, requesterID int64) error
+		CanView(ctx context.Context, authorID, viewerID int64) (bool, error)
 	}
 	Roles interface {
 		GetByName(ctx context.Context, roleName string) (*Role, error)
+		GetByID(ctx context.Context, id int64) (*Role, error)
+		List(ctx context.Context) ([]Role, error)
+		Create(ctx context.Context, role *Role) error
+		Update(ctx context.Context, role *Role) error
+		Delete(ctx context.Context, id int64) error
+	}
+	Permissions interface {
+		List(ctx context.Context) ([]Permission, error)
+		GetByRoleID(ctx context.Context, roleID int64) ([]string, error)
+		Grant(ctx context.Context, roleID int64, permission string) error
+		Revoke(ctx context.Context, roleID int64, permission string) error
+	}
+	Audit interface {
+		List(ctx context.Context, aq AuditQuery) ([]AuditEvent, error)
+	}
+	Idempotency interface {
+		Begin(ctx context.Context, scope, key, fingerprint string, ttl time.Duration) (*IdempotentResponse, error)
+		Complete(ctx context.Context, scope, key string, resp *IdempotentResponse) error
+		Delete(ctx context.Context, scope, key string) error
+		PurgeExpired(context.Context) (int64, error)
+	}
+	Reposts interface {
+		Create(ctx context.Context, userID, postID int64) error
+		Delete(ctx context.Context, userID, postID int64) error
+	}
+	Polls interface {
+		GetByPostIDs(ctx context.Context, postIDs []int64, viewerID int64) (map[int64]*Poll, error)
+		Vote(ctx context.Context, postID, userID int64, optionIDs []int64) error
+	}
+	Tags interface {
+		GetPosts(ctx context.Context, tag string, viewerID int64, cq PostCursorQuery) ([]PostWithMetadata, error)
+		Trending(ctx context.Context, tq TrendingQuery) ([]TrendingTag, error)
+		RefreshTrending(ctx context.Context) (bool, error)
+		Follow(ctx context.Context, userID int64, tag string) error
+		Unfollow(ctx context.Context, userID int64, tag string) error
+		GetFollowed(ctx context.Context, userID int64) ([]string, error)
+	}
+	LinkPreviews interface {
+		IsFresh(ctx context.Context, url string, maxAge time.Duration) (bool, error)
+		Save(ctx context.Context, url string, preview *LinkPreview) error
+	}
+	Logins interface {
+		GetThrottle(ctx context.Context, key string) (*LoginThrottle, error)
+		Fail(ctx context.Context, key string, policy LoginPolicy) (*LoginThrottle, error)
+		Reset(ctx context.Context, key string) error
+		CreateUnlockToken(ctx context.Context, userID int64, key, token string, exp time.Duration) error
+		Unlock(ctx context.Context, token, ipAddress, userAgent string) error
+		CreateEvent(ctx context.Context, event *LoginEvent) error
+		GetEvents(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]LoginEvent, error)
+		PurgeExpired(ctx context.Context, window time.Duration) (int64, error)
+	}
+	TwoFactor interface {
+		Get(ctx context.Context, userID int64) (*TwoFactor, error)
+		Enroll(ctx context.Context, userID int64, secret string) error
+		Enable(ctx context.Context, userID, step int64) ([]string, error)
+		Disable(ctx context.Context, userID int64) error
+		UseStep(ctx context.Context, userID, step int64) error
+		UseRecoveryCode(ctx context.Context, userID int64, code string) error
+		RegenerateRecoveryCodes(ctx context.Context, userID int64) ([]string, error)
+	}
+	Identities interface {
+		CreateState(ctx context.Context, state string, st *OAuthState, exp time.Duration) error
+		ConsumeState(ctx context.Context, state string) (*OAuthState, error)
+		GetUserID(ctx context.Context, provider, subject string) (int64, error)
+		LinkByEmail(ctx context.Context, provider, subject, email string) (int64, error)
+		CreateUser(ctx context.Context, user *User, provider, subject string) error
+		PurgeExpiredStates(context.Context) (int64, error)
+	}
+	PersonalTokens interface {
+		Create(ctx context.Context, token *PersonalAccessToken, plain string, exp time.Duration) error
+		GetByUserID(ctx context.Context, userID int64) ([]PersonalAccessToken, error)
+		GetByToken(ctx context.Context, plain string) (*PersonalAccessToken, bool, error)
+		Touch(ctx context.Context, id int64) error
+		Delete(ctx context.Context, userID, id int64) error
+	}
+	Sessions interface {
+		Create(ctx context.Context, session *Session) error
+		GetByID(ctx context.Context, id string) (*Session, error)
+		GetActiveByUserID(ctx context.Context, userID int64) ([]Session, error)
+		Touch(ctx context.Context, id string) (time.Time, error)
+		Revoke(ctx context.Context, userID int64, id string) error
+		RevokeOthers(ctx context.Context, userID int64, keepID string) ([]string, error)
+	}
+	Blocks interface {
+		Block(ctx context.Context, blockerID, blockedID int64) error
+		Unblock(ctx context.Context, blockerID, blockedID int64) error
+		IsBlocked(ctx context.Context, blockerID, blockedID int64) (bool, error)
+	}
+	Mutes interface {
+		Mute(ctx context.Context, muterID, mutedID int64) error
+		Unmute(ctx context.Context, muterID, mutedID int64) error
+	}
+	Reports interface {
+		Create(ctx context.Context, report *Report) error
+		GetByID(ctx context.Context, id int64) (*Report, error)
+		List(ctx context.Context, rq ReportQuery) ([]Report, error)
+		Claim(ctx context.Context, reportID, moderatorID int64) error
+		Resolve(ctx context.Context, reportID, moderatorID int64, resolution, note string) (*ResolvedTarget, error)
+		Dismiss(ctx context.Context, reportID, moderatorID int64, note string) error
+	}
+	Conversations interface {
+		GetOrCreate(ctx context.Context, userID, otherID int64) (*Conversation, bool, error)
+		GetByID(ctx context.Context, id int64) (*Conversation, error)
+		GetByUserID(ctx context.Context, userID int64, fq PaginatedFeedQuery) ([]Conversation, error)
+	}
+	Messages interface {
+		Create(ctx context.Context, message *Message) error
+		GetByConversationID(ctx context.Context, conversationID int64, cq CursorQuery) ([]Message, error)
+		MarkRead(ctx context.Context, conversationID, readerID int64) error
 	}
 }
 
@@ -50,9 +178,44 @@ func NewStorage(db *sql.DB) Storage {
 		Comments:  &CommentStore{db: db},
 		Followers: &FollowerStore{db: db},
 		Roles:     &RoleStore{db: db},
+		Blocks:    &BlockStore{db: db},
+		Mutes:     &MuteStore{db: db},
+		Reports:   &ReportStore{db: db},
+
+		Permissions: &PermissionStore{db: db},
+		Audit:       &AuditStore{db: db},
+		Idempotency: &IdempotencyStore{db: db},
+		Reposts:     &RepostStore{db: db},
+		Polls:       &PollStore{db: db},
+		Tags:        &TagStore{db: db},
+
+		LinkPreviews: &LinkPreviewStore{db: db},
+		Logins:       &LoginStore{db: db},
+		TwoFactor:    &TwoFactorStore{db: db},
+		Identities:   &IdentityStore{db: db},
+
+		PersonalTokens: &PersonalTokenStore{db: db},
+		Sessions:       &SessionStore{db: db},
+
+		Conversations: &ConversationStore{db: db},
+		Messages:      &MessageStore{db: db},
 	}
 }
 
+// requireRows turns an update or delete that matched nothing into ErrNotFound.
+func requireRows(res sql.Result) error {
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
 func withTx(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
@@ -64,5 +227,13 @@ func withTx(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
 		return err
 	}
 
+	// the change and its audit event are committed together or not at all
+	if event := auditEventFromContext(ctx); event != nil {
+		if err := createAuditEvent(ctx, tx, event); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
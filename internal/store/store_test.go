@@ -0,0 +1,112 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDB returns a database with every migration applied, in a schema of
+// its own that is dropped after the test. The tests run against the
+// Postgres at TEST_DB_ADDR and are skipped without it.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	addr := os.Getenv("TEST_DB_ADDR")
+	if addr == "" {
+		t.Skip("TEST_DB_ADDR is not set")
+	}
+
+	admin, err := sql.Open("postgres", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	// the migrations create their extensions only if missing, so they stay
+	// in public where every test schema finds them
+	for _, ext := range []string{"citext", "pg_trgm"} {
+		if _, err := admin.Exec(`CREATE EXTENSION IF NOT EXISTS ` + ext + ` WITH SCHEMA public;`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema + `;`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE;`); err != nil {
+			t.Errorf("dropping %s: %v", schema, err)
+		}
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(addr, schema+",public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../../cmd/migrate/migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+
+	for _, m := range migrations {
+		query, err := os.ReadFile(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := db.Exec(string(query)); err != nil {
+			t.Fatalf("migration %s: %v", filepath.Base(m), err)
+		}
+	}
+
+	return db
+}
+
+// withSearchPath adds the search_path run-time parameter to a URL or a
+// key=value connection string.
+func withSearchPath(addr, searchPath string) string {
+	if strings.HasPrefix(addr, "postgres://") || strings.HasPrefix(addr, "postgresql://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return addr
+		}
+
+		q := u.Query()
+		q.Set("search_path", searchPath)
+		u.RawQuery = q.Encode()
+
+		return u.String()
+	}
+
+	return addr + " search_path=" + searchPath
+}
+
+// createTestUser inserts an active user with the user role and returns its
+// id.
+func createTestUser(t *testing.T, db *sql.DB, username string, private bool) int64 {
+	t.Helper()
+
+	query := `
+		INSERT INTO users (username, email, password, role_id, is_active, is_private)
+		VALUES ($1, $2, '', (SELECT id FROM roles WHERE name = 'user'), true, $3)
+		RETURNING id;
+	`
+
+	var id int64
+	if err := db.QueryRow(query, username, username+"@example.com", private).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
@@ -0,0 +1,283 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	"golang.org/x/text/unicode/norm"
+)
+
+const MaxTagLength = 50
+
+var ErrInvalidTag = errors.New("tags must be 1 to 50 letters, digits, hyphens or underscores")
+
+// NormalizeTag returns the form tags are stored and looked up in: NFKC
+// normalized, lower case and without a leading #, so "#Go" and "go" are
+// the same tag.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+	tag = strings.ToLower(norm.NFKC.String(tag))
+
+	if n := utf8.RuneCountInString(tag); n == 0 || n > MaxTagLength {
+		return "", ErrInvalidTag
+	}
+
+	for _, r := range tag {
+		if r != '_' && r != '-' && !unicode.In(r, unicode.Letter, unicode.Digit, unicode.Mark) {
+			return "", ErrInvalidTag
+		}
+	}
+
+	return tag, nil
+}
+
+// normalizeTags normalizes every tag and drops duplicates, keeping the
+// order they were given in.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := map[string]bool{}
+
+	for _, tag := range tags {
+		t, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+
+		if !seen[t] {
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+
+	return normalized, nil
+}
+
+type TrendingTag struct {
+	Tag        string `json:"tag"`
+	PostsCount int    `json:"posts_count"`
+}
+
+type TrendingQuery struct {
+	Window string `json:"window" validate:"oneof=hour day week"`
+	Limit  int    `json:"limit" validate:"gte=1,lte=50"`
+}
+
+func (tq TrendingQuery) Parse(r *http.Request) (TrendingQuery, error) {
+	qs := r.URL.Query()
+
+	window := qs.Get("window")
+	if window != "" {
+		tq.Window = window
+	}
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return tq, err
+		}
+
+		tq.Limit = l
+	}
+
+	return tq, nil
+}
+
+// trendingColumns maps a window to its count in the trending_tags view.
+var trendingColumns = map[string]string{
+	"hour": "hour_count",
+	"day":  "day_count",
+	"week": "week_count",
+}
+
+type TagStore struct {
+	db *sql.DB
+}
+
+// GetPosts returns the published posts with the tag that the viewer may
+// see, newest first.
+func (s *TagStore) GetPosts(ctx context.Context, tag string, viewerID int64, cq PostCursorQuery) ([]PostWithMetadata, error) {
+	query := `
+		SELECT
+			p.id, p.user_id, p.title, p.content, p.status, p.published_at, p.created_at, p.updated_at,
+			p.version, ` + postEdited + `, p.tags, p.quoted_post_id, p.is_quote,
+			p.link_url, ` + linkPreviewColumn + `,
+			u.username,
+			(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments_count,
+			(SELECT COUNT(*) FROM reposts rc WHERE rc.post_id = p.id) AS reposts_count
+		FROM posts p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.tags @> ARRAY[$1]::VARCHAR(100)[]
+		AND p.status = 'published'
+		AND NOT p.is_hidden
+		AND ($3::TIMESTAMPTZ IS NULL OR (p.published_at, p.id) < ($3, $4))
+		AND ` + relationshipFilter("p.user_id", "$2") + `
+		AND ` + privacyFilter("p.user_id", "$2") + `
+		ORDER BY p.published_at DESC, p.id DESC
+		LIMIT $5;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, tag, viewerID, cq.afterPublishedAt, cq.afterID, cq.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []PostWithMetadata{}
+	for rows.Next() {
+		var p PostWithMetadata
+		err := rows.Scan(
+			&p.ID,
+			&p.UserID,
+			&p.Title,
+			&p.Content,
+			&p.Status,
+			&p.PublishedAt,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+			&p.Version,
+			&p.Edited,
+			pq.Array(&p.Tags),
+			&p.QuotedPostID,
+			&p.IsQuote,
+			&p.LinkURL,
+			&p.LinkPreview,
+			&p.User.Username,
+			&p.CommentCount,
+			&p.RepostCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, p)
+	}
+
+	return posts, rows.Err()
+}
+
+// Trending returns the most used tags of the window, as of the last
+// RefreshTrending.
+func (s *TagStore) Trending(ctx context.Context, tq TrendingQuery) ([]TrendingTag, error) {
+	column, ok := trendingColumns[tq.Window]
+	if !ok {
+		return nil, errors.New("unknown trending window")
+	}
+
+	query := `
+		SELECT tag, ` + column + `
+		FROM trending_tags
+		WHERE ` + column + ` > 0
+		ORDER BY ` + column + ` DESC, tag
+		LIMIT $1;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, tq.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TrendingTag{}
+	for rows.Next() {
+		var t TrendingTag
+		if err := rows.Scan(&t.Tag, &t.PostsCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	return tags, rows.Err()
+}
+
+// RefreshTrending recomputes the trending_tags view. Only one replica
+// refreshes at a time, the others get false back and skip the round.
+func (s *TagStore) RefreshTrending(ctx context.Context) (bool, error) {
+	refreshed := false
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// the lock is released when the transaction ends
+		query := `SELECT pg_try_advisory_xact_lock(hashtext('trending_tags'));`
+
+		var locked bool
+		if err := tx.QueryRowContext(ctx, query).Scan(&locked); err != nil {
+			return err
+		}
+
+		if !locked {
+			return nil
+		}
+
+		// concurrently keeps the view readable while it is rebuilt
+		if _, err := tx.ExecContext(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY trending_tags;`); err != nil {
+			return err
+		}
+
+		refreshed = true
+		return nil
+	})
+
+	return refreshed, err
+}
+
+func (s *TagStore) Follow(ctx context.Context, userID int64, tag string) error {
+	query := `INSERT INTO tag_follows (user_id, tag) VALUES ($1, $2);`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID, tag)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *TagStore) Unfollow(ctx context.Context, userID int64, tag string) error {
+	query := `DELETE FROM tag_follows WHERE user_id = $1 AND tag = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID, tag)
+	return err
+}
+
+func (s *TagStore) GetFollowed(ctx context.Context, userID int64) ([]string, error) {
+	query := `SELECT tag FROM tag_follows WHERE user_id = $1 ORDER BY tag;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
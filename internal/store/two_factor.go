@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+)
+
+const recoveryCodeCount = 10
+
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+
+type TwoFactor struct {
+	UserID       int64
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+}
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+// Get returns the TOTP enrolment of the user, ErrNotFound when there is
+// none.
+func (s *TwoFactorStore) Get(ctx context.Context, userID int64) (*TwoFactor, error) {
+	query := `SELECT user_id, secret, enabled, last_used_step FROM user_totp WHERE user_id = $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	tf := &TwoFactor{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&tf.UserID, &tf.Secret, &tf.Enabled, &tf.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return tf, nil
+}
+
+// Enroll stores a new secret waiting for confirmation, replacing one that
+// was never confirmed.
+func (s *TwoFactorStore) Enroll(ctx context.Context, userID int64, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE NOT user_totp.enabled;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	if err := requireRows(res); err != nil {
+		return ErrTwoFactorEnabled
+	}
+
+	return nil
+}
+
+// Enable confirms the secret with the step of a valid code and returns the
+// recovery codes of the user. They are only stored hashed.
+func (s *TwoFactorStore) Enable(ctx context.Context, userID, step int64) ([]string, error) {
+	var codes []string
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE user_totp SET enabled = true, last_used_step = $2
+			WHERE user_id = $1 AND NOT enabled;
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, userID, step)
+		if err != nil {
+			return err
+		}
+
+		if err := requireRows(res); err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable removes the secret and the recovery codes of the user.
+func (s *TwoFactorStore) Disable(ctx context.Context, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1;`, userID); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1 AND enabled;`, userID)
+		if err != nil {
+			return err
+		}
+
+		return requireRows(res)
+	})
+}
+
+// UseStep marks the step of a valid code as used. A step at or before the
+// last one used fails with ErrNotFound, the code was replayed.
+func (s *TwoFactorStore) UseStep(ctx context.Context, userID, step int64) error {
+	query := `
+		UPDATE user_totp SET last_used_step = $2
+		WHERE user_id = $1 AND enabled AND last_used_step < $2;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	return requireRows(res)
+}
+
+// UseRecoveryCode spends a recovery code, ErrNotFound when it is unknown or
+// already used.
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `
+		UPDATE user_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, tokenHash(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	return requireRows(res)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of the user.
+func (s *TwoFactorStore) RegenerateRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	var codes []string
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var err error
+		codes, err = replaceRecoveryCodes(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1;`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		query := `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2);`
+		if _, err := tx.ExecContext(ctx, query, userID, tokenHash(normalizeRecoveryCode(code))); err != nil {
+			return nil, err
+		}
+
+		codes[i] = code
+	}
+
+	return codes, nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns a random code formatted as xxxxx-xxxxx.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+// IsRecoveryCode reports whether the input looks like a recovery code rather
+// than a TOTP code.
+func IsRecoveryCode(code string) bool {
+	return len(normalizeRecoveryCode(code)) == 10
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -24,7 +25,12 @@ type User struct {
 	CreatedAt string   `json:"created_at"`
 	IsActive  bool     `json:"is_active"`
 	RoleID    int64    `json:"role_id"`
-	Role      Role     `json:"role"`	
+	Role      Role     `json:"role"`
+
+	IsSuspended      bool       `json:"is_suspended"`
+	AcceptsMessages  bool       `json:"accepts_messages"`
+	IsPrivate        bool       `json:"is_private"`
+	TokensValidAfter *time.Time `json:"tokens_valid_after,omitempty"`
 }
 
 type password struct {
@@ -48,6 +54,18 @@ func (p *password) Compare(text string) error {
 	return bcrypt.CompareHashAndPassword(p.hash, []byte(text))
 }
 
+// dummyHash is compared against when no user has the email, so a failed
+// login takes as long whether or not the email is registered.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not-a-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// CompareDummyPassword does the work of a password check that always fails.
+func CompareDummyPassword(text string) {
+	bcrypt.CompareHashAndPassword(dummyHash(), []byte(text))
+}
+
 type UserStore struct {
 	db *sql.DB
 }
@@ -93,10 +111,12 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, u *User) error {
 
 func (s *UserStore) GetByID(ctx context.Context, userID int64) (*User, error) {
 	query := `
-		SELECT users.id, users.username, users.email, users.password, users.created_at, roles.*
+		SELECT users.id, users.username, users.email, users.password, users.created_at,
+			users.is_active, users.accepts_messages, users.is_private, users.tokens_valid_after,
+			roles.id, roles.name, roles.level, roles.description, roles.require_2fa
 		FROM users 
 		JOIN roles ON (users.role_id = roles.id)
-		WHERE users.id = $1 AND users.is_active = true;
+		WHERE users.id = $1 AND users.is_active = true AND users.is_suspended = false;
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -109,10 +129,15 @@ func (s *UserStore) GetByID(ctx context.Context, userID int64) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.CreatedAt,
+		&user.IsActive,
+		&user.AcceptsMessages,
+		&user.IsPrivate,
+		&user.TokensValidAfter,
 		&user.Role.ID,
 		&user.Role.Name,
 		&user.Role.Level,
 		&user.Role.Description,
+		&user.Role.Require2FA,
 	)
 	if err != nil {
 		switch err {
@@ -122,6 +147,7 @@ func (s *UserStore) GetByID(ctx context.Context, userID int64) (*User, error) {
 			return nil, err
 		}
 	}
+	user.RoleID = user.Role.ID
 
 	return &user, nil
 }
@@ -142,13 +168,17 @@ func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token strin
 	})
 }
 
-func (s *UserStore) Activate(ctx context.Context, token string) error {
-	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+// Activate activates the user invited with the token and returns its id.
+func (s *UserStore) Activate(ctx context.Context, token string) (int64, error) {
+	var userID int64
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
 		// find the user
 		user, err := s.getUserFromInvitation(ctx, tx, token)
 		if err != nil {
 			return err
 		}
+		userID = user.ID
 
 		// update the user
 		user.IsActive = true
@@ -163,6 +193,8 @@ func (s *UserStore) Activate(ctx context.Context, token string) error {
 
 		return nil
 	})
+
+	return userID, err
 }
 
 func (s *UserStore) Delete(ctx context.Context, userID int64) error {
@@ -179,6 +211,38 @@ func (s *UserStore) Delete(ctx context.Context, userID int64) error {
 	})
 }
 
+// UpdateSettings saves the user's settings. Making a private account public
+// approves every pending follow request.
+func (s *UserStore) UpdateSettings(ctx context.Context, user *User) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET accepts_messages = $1, is_private = $2 WHERE id = $3;`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		_, err := tx.ExecContext(ctx, query, user.AcceptsMessages, user.IsPrivate, user.ID)
+		if err != nil {
+			return err
+		}
+
+		if user.IsPrivate {
+			return nil
+		}
+
+		query = `
+			WITH approved AS (
+				DELETE FROM follow_requests WHERE user_id = $1
+				RETURNING user_id, requester_id
+			)
+			INSERT INTO followers (user_id, follower_id)
+			SELECT user_id, requester_id FROM approved
+			ON CONFLICT DO NOTHING;
+		`
+		_, err = tx.ExecContext(ctx, query, user.ID)
+		return err
+	})
+}
+
 // ----------	Private Method	-----------
 
 func (s *UserStore) getUserFromInvitation(ctx context.Context, tx *sql.Tx, token string) (*User, error) {
@@ -272,8 +336,12 @@ func (s *UserStore) delete(ctx context.Context, tx *sql.Tx, id int64) error {
 
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, username, email, password, created_at FROM users
-		WHERE email = $1 AND is_active = true;
+		SELECT users.id, users.username, users.email, users.password, users.created_at,
+			users.is_active, users.tokens_valid_after,
+			roles.id, roles.name, roles.level, roles.description, roles.require_2fa
+		FROM users
+		JOIN roles ON (users.role_id = roles.id)
+		WHERE users.email = $1 AND users.is_active = true AND users.is_suspended = false;
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -285,6 +353,13 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.CreatedAt,
+		&user.IsActive,
+		&user.TokensValidAfter,
+		&user.Role.ID,
+		&user.Role.Name,
+		&user.Role.Level,
+		&user.Role.Description,
+		&user.Role.Require2FA,
 	)
 	if err != nil {
 		switch err {
@@ -294,6 +369,176 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 			return nil, err
 		}
 	}
+	user.RoleID = user.Role.ID
 
 	return user, nil
 }
+
+// List returns every user matching the filter, whatever their status.
+func (s *UserStore) List(ctx context.Context, fq UserFilterQuery) ([]User, error) {
+	query := `
+		SELECT u.id, u.username, u.email, u.created_at, u.is_active, u.is_suspended,
+			r.id, r.name, r.level, r.description
+		FROM users u
+		JOIN roles r ON (u.role_id = r.id)
+		WHERE ($1 = '' OR r.name = $1)
+			AND ($2::BOOLEAN IS NULL OR u.is_active = $2)
+			AND ($3::BOOLEAN IS NULL OR u.is_suspended = $3)
+			AND ($4 = '' OR u.username ILIKE '%' || $4 || '%' OR u.email ILIKE '%' || $4 || '%')
+		ORDER BY u.id ASC
+		LIMIT $5 OFFSET $6;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		query,
+		fq.Role,
+		fq.Active,
+		fq.Suspended,
+		fq.Search,
+		fq.Limit,
+		fq.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		err := rows.Scan(
+			&u.ID,
+			&u.Username,
+			&u.Email,
+			&u.CreatedAt,
+			&u.IsActive,
+			&u.IsSuspended,
+			&u.Role.ID,
+			&u.Role.Name,
+			&u.Role.Level,
+			&u.Role.Description,
+		)
+		if err != nil {
+			return nil, err
+		}
+		u.RoleID = u.Role.ID
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// SetRole moves the user to the named role.
+func (s *UserStore) SetRole(ctx context.Context, userID int64, roleName string) error {
+	query := `
+		UPDATE users SET role_id = roles.id
+		FROM roles
+		WHERE users.id = $1 AND roles.name = $2;
+	`
+	return s.exec(ctx, userID, query, roleName)
+}
+
+func (s *UserStore) SetSuspended(ctx context.Context, userID int64, suspended bool) error {
+	query := `UPDATE users SET is_suspended = $2 WHERE id = $1;`
+
+	return s.exec(ctx, userID, query, suspended)
+}
+
+// ForceActivate activates the user without an invitation token.
+func (s *UserStore) ForceActivate(ctx context.Context, userID int64) error {
+	return s.modify(ctx, userID, func(ctx context.Context, tx *sql.Tx) error {
+		query := `UPDATE users SET is_active = true WHERE id = $1;`
+
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+
+		return s.deleteUserInvitations(ctx, tx, userID)
+	})
+}
+
+// RevokeTokens invalidates every token issued to the user so far.
+func (s *UserStore) RevokeTokens(ctx context.Context, userID int64) error {
+	query := `UPDATE users SET tokens_valid_after = NOW() WHERE id = $1;`
+
+	return s.exec(ctx, userID, query)
+}
+
+// exec runs a single-statement update of the user, with the user's id as
+// the first argument.
+func (s *UserStore) exec(ctx context.Context, userID int64, query string, args ...any) error {
+	return s.modify(ctx, userID, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, append([]any{userID}, args...)...)
+		if err != nil {
+			return err
+		}
+
+		return requireRows(res)
+	})
+}
+
+// modify locks the user and runs fn in a transaction, failing with
+// ErrNotFound for unknown users. Audited updates record the user's state
+// before and after fn.
+func (s *UserStore) modify(ctx context.Context, userID int64, fn func(context.Context, *sql.Tx) error) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		before, err := s.auditState(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(ctx, tx); err != nil {
+			return err
+		}
+
+		if event := auditEventFromContext(ctx); event != nil {
+			after, err := s.auditState(ctx, tx, userID)
+			if err != nil {
+				return err
+			}
+
+			event.Before = before
+			event.After = after
+		}
+
+		return nil
+	})
+}
+
+func (s *UserStore) auditState(ctx context.Context, tx *sql.Tx, userID int64) (map[string]any, error) {
+	query := `
+		SELECT r.name, u.is_active, u.is_suspended, u.tokens_valid_after
+		FROM users u
+		JOIN roles r ON (u.role_id = r.id)
+		WHERE u.id = $1
+		FOR UPDATE OF u;
+	`
+	var (
+		role              string
+		active, suspended bool
+		tokensValidAfter  *time.Time
+	)
+
+	err := tx.QueryRowContext(ctx, query, userID).Scan(&role, &active, &suspended, &tokensValidAfter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return map[string]any{
+		"role":               role,
+		"is_active":          active,
+		"is_suspended":       suspended,
+		"tokens_valid_after": tokensValidAfter,
+	}, nil
+}
@@ -0,0 +1,29 @@
+package store
+
+import "fmt"
+
+// relationshipFilter returns a WHERE condition that hides rows written by
+// authorCol from the viewer bound to viewerArg: authors who blocked the
+// viewer, authors the viewer blocked, and authors the viewer muted.
+func relationshipFilter(authorCol, viewerArg string) string {
+	return fmt.Sprintf(`
+		NOT EXISTS (
+			SELECT 1 FROM user_blocks ub
+			WHERE (ub.blocker_id = %[1]s AND ub.blocked_id = %[2]s)
+				OR (ub.blocker_id = %[2]s AND ub.blocked_id = %[1]s)
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM user_mutes um
+			WHERE um.muter_id = %[2]s AND um.muted_id = %[1]s
+		)`, authorCol, viewerArg)
+}
+
+// privacyFilter returns a WHERE condition that hides rows written by
+// private authors from viewers who are not their approved followers.
+func privacyFilter(authorCol, viewerArg string) string {
+	return fmt.Sprintf(`(
+		%[1]s = %[2]s
+		OR NOT EXISTS (SELECT 1 FROM users pu WHERE pu.id = %[1]s AND pu.is_private)
+		OR EXISTS (SELECT 1 FROM followers pf WHERE pf.user_id = %[1]s AND pf.follower_id = %[2]s)
+	)`, authorCol, viewerArg)
+}
@@ -0,0 +1,284 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	ErrBlockedAddress = errors.New("unfurl: address is not allowed")
+	ErrNotHTML        = errors.New("unfurl: response is not html")
+)
+
+type Config struct {
+	// Timeout bounds the whole fetch, redirects and body included
+	Timeout      time.Duration
+	MaxBodyBytes int64
+	MaxRedirects int
+	UserAgent    string
+	// AllowAddr decides which addresses may be dialed, by default only
+	// public ones. Tests use it to reach a local server.
+	AllowAddr func(netip.AddrPort) bool
+}
+
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+// Unfurler fetches pages to build link previews. Every connection it makes
+// is checked against the resolved address, so neither the URL, a redirect
+// nor a DNS answer can point it at a private network.
+type Unfurler struct {
+	client *http.Client
+	config Config
+}
+
+func New(config Config) *Unfurler {
+	allow := config.AllowAddr
+	if allow == nil {
+		allow = func(addrPort netip.AddrPort) bool {
+			return allowedAddr(addrPort.Addr())
+		}
+	}
+
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+
+			if !allow(addrPort) {
+				return ErrBlockedAddress
+			}
+
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// a proxy would make the dial check meaningless
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    config.Timeout,
+		ResponseHeaderTimeout:  config.Timeout,
+		MaxResponseHeaderBytes: 64 << 10,
+		DisableKeepAlives:      true,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > config.MaxRedirects {
+				return fmt.Errorf("unfurl: more than %d redirects", config.MaxRedirects)
+			}
+
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrBlockedAddress
+			}
+
+			return nil
+		},
+	}
+
+	return &Unfurler{client: client, config: config}
+}
+
+// Unfurl fetches the page and reads its OpenGraph and Twitter card tags,
+// falling back to the title and description meta tags.
+func (u *Unfurler) Unfurl(ctx context.Context, rawURL string) (*Preview, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, ErrBlockedAddress
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", u.config.UserAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unfurl: unexpected status %s", resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	// relative image URLs resolve against where the redirects ended up
+	preview := parse(io.LimitReader(resp.Body, u.config.MaxBodyBytes), resp.Request.URL)
+	preview.URL = rawURL
+
+	return preview, nil
+}
+
+// parse reads the meta tags in the head of the document.
+func parse(r io.Reader, base *url.URL) *Preview {
+	meta := map[string]string{}
+	var title string
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		name, hasAttr := z.TagName()
+		tag := string(name)
+
+		if (tt == html.EndTagToken && tag == "head") || (tt == html.StartTagToken && tag == "body") {
+			break
+		}
+
+		if tt == html.StartTagToken && tag == "title" && title == "" {
+			if z.Next() == html.TextToken {
+				title = string(z.Text())
+			}
+			continue
+		}
+
+		if tag != "meta" || !hasAttr || (tt != html.StartTagToken && tt != html.SelfClosingTagToken) {
+			continue
+		}
+
+		var key, content string
+		for {
+			k, v, more := z.TagAttr()
+			switch string(k) {
+			case "property", "name":
+				key = strings.ToLower(string(v))
+			case "content":
+				content = string(v)
+			}
+			if !more {
+				break
+			}
+		}
+
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = content
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := strings.TrimSpace(meta[k]); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	preview := &Preview{
+		Title:       truncate(first("og:title", "twitter:title"), 300),
+		Description: truncate(first("og:description", "twitter:description", "description"), 1000),
+		SiteName:    truncate(first("og:site_name"), 100),
+	}
+
+	// the tokenizer already unescaped the text
+	if preview.Title == "" {
+		preview.Title = truncate(strings.TrimSpace(title), 300)
+	}
+
+	if image := first("og:image", "og:image:url", "twitter:image"); image != "" {
+		if u, err := base.Parse(image); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			preview.ImageURL = u.String()
+		}
+	}
+
+	return preview
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+// FirstURL returns the first http(s) URL in the text, or "" when there is
+// none.
+func FirstURL(text string) string {
+	match := urlPattern.FindString(text)
+
+	// punctuation at the end belongs to the sentence, not the link
+	match = strings.TrimRight(match, ".,;:!?)]}")
+	if len(match) > 2048 {
+		return ""
+	}
+
+	u, err := url.Parse(match)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	return match
+}
+
+// blockedPrefixes are special-purpose ranges the netip predicates in
+// allowedAddr do not cover.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+func allowedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+
+	for _, p := range blockedPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	return string([]rune(s)[:n])
+}
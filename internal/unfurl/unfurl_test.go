@@ -0,0 +1,306 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestUnfurler returns an unfurler that may only dial srv, on top of the
+// public addresses it allows anyway.
+func newTestUnfurler(t *testing.T, srv *httptest.Server, config Config) *Unfurler {
+	t.Helper()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := netip.MustParseAddrPort(u.Host)
+
+	if config.Timeout == 0 {
+		config.Timeout = time.Second * 2
+	}
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = 64 << 10
+	}
+	if config.MaxRedirects == 0 {
+		config.MaxRedirects = 3
+	}
+	config.AllowAddr = func(addrPort netip.AddrPort) bool {
+		return addrPort == server || allowedAddr(addrPort.Addr())
+	}
+
+	return New(config)
+}
+
+func TestUnfurl(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        Preview
+	}{
+		{
+			name:        "opengraph",
+			contentType: "text/html; charset=utf-8",
+			body: `<html><head>
+				<title>Fallback</title>
+				<meta property="og:title" content="Tom &amp; Jerry">
+				<meta property="og:description" content="A cat and a mouse">
+				<meta property="og:site_name" content="Cartoons">
+				<meta property="og:image" content="/img/cover.png">
+				</head><body></body></html>`,
+			want: Preview{
+				Title:       "Tom & Jerry",
+				Description: "A cat and a mouse",
+				SiteName:    "Cartoons",
+				ImageURL:    "/img/cover.png",
+			},
+		},
+		{
+			name:        "twitter card",
+			contentType: "text/html",
+			body: `<head>
+				<meta name="twitter:title" content="Card title">
+				<meta name="twitter:description" content="Card description">
+				<meta name="twitter:image" content="https://cdn.example.com/a.png">
+				</head>`,
+			want: Preview{
+				Title:       "Card title",
+				Description: "Card description",
+				ImageURL:    "https://cdn.example.com/a.png",
+			},
+		},
+		{
+			name:        "title fallback",
+			contentType: "application/xhtml+xml",
+			body:        `<head><title> Fish &amp;amp; Chips </title><meta name="description" content="Plain"></head>`,
+			// unescaped once, the page meant a literal "&amp;"
+			want: Preview{Title: "Fish &amp; Chips", Description: "Plain"},
+		},
+		{
+			name:        "tags after the head are ignored",
+			contentType: "text/html",
+			body:        `<head><title>Head</title></head><body><meta property="og:title" content="Body"></body>`,
+			want:        Preview{Title: "Head"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			u := newTestUnfurler(t, srv, Config{})
+
+			got, err := u.Unfurl(context.Background(), srv.URL+"/page")
+			if err != nil {
+				t.Fatalf("Unfurl() error = %v", err)
+			}
+
+			want := tt.want
+			want.URL = srv.URL + "/page"
+			if strings.HasPrefix(want.ImageURL, "/") {
+				want.ImageURL = srv.URL + want.ImageURL
+			}
+
+			if *got != want {
+				t.Errorf("Unfurl() = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestUnfurlErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		handler http.HandlerFunc
+		wantErr error
+	}{
+		{
+			name: "not html",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"title": "json"}`))
+			},
+			wantErr: ErrNotHTML,
+		},
+		{
+			name: "image",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write([]byte("\x89PNG"))
+			},
+			wantErr: ErrNotHTML,
+		},
+		{
+			name: "redirect to a private address",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "http://10.0.0.1/admin", http.StatusFound)
+			},
+			wantErr: ErrBlockedAddress,
+		},
+		{
+			name: "redirect to loopback on another port",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "http://127.0.0.1:1/", http.StatusFound)
+			},
+			wantErr: ErrBlockedAddress,
+		},
+		{
+			name: "redirect to another scheme",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+			},
+			wantErr: ErrBlockedAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			u := newTestUnfurler(t, srv, tt.config)
+
+			_, err := u.Unfurl(context.Background(), srv.URL)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Unfurl() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnfurlBlocksPrivateTargets(t *testing.T) {
+	// the default policy, without the test server allowed
+	u := New(Config{Timeout: time.Second, MaxBodyBytes: 1024, MaxRedirects: 3})
+
+	for _, target := range []string{
+		"http://127.0.0.1/",
+		"http://[::1]/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://192.168.1.1/",
+		"ftp://example.com/",
+	} {
+		if _, err := u.Unfurl(context.Background(), target); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("Unfurl(%q) error = %v, want %v", target, err, ErrBlockedAddress)
+		}
+	}
+}
+
+func TestUnfurlLimits(t *testing.T) {
+	t.Run("body size", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<head><title>Early</title><!--`))
+			w.Write([]byte(strings.Repeat("x", 4096)))
+			w.Write([]byte(`--><meta property="og:title" content="Late"></head>`))
+		}))
+		defer srv.Close()
+
+		u := newTestUnfurler(t, srv, Config{MaxBodyBytes: 1024})
+
+		got, err := u.Unfurl(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatalf("Unfurl() error = %v", err)
+		}
+
+		if got.Title != "Early" {
+			t.Errorf("Title = %q, want the tags past MaxBodyBytes unread", got.Title)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-release
+		}))
+		defer srv.Close()
+		defer close(release)
+
+		u := newTestUnfurler(t, srv, Config{Timeout: time.Millisecond * 100})
+
+		// the headers arrived, so the page cut off by the timeout still
+		// unfurls to what was read, only the wait matters
+		start := time.Now()
+		u.Unfurl(context.Background(), srv.URL)
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Unfurl() took %v, want it cut off after the timeout", elapsed)
+		}
+	})
+
+	t.Run("redirects", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/again", http.StatusFound)
+		}))
+		defer srv.Close()
+
+		u := newTestUnfurler(t, srv, Config{MaxRedirects: 2})
+
+		if _, err := u.Unfurl(context.Background(), srv.URL); err == nil || !strings.Contains(err.Error(), "redirects") {
+			t.Errorf("Unfurl() error = %v, want too many redirects", err)
+		}
+	})
+}
+
+func TestAllowedAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.0.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, tt := range tests {
+		if got := allowedAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("allowedAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestFirstURL(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"see https://example.com/a?b=c.", "https://example.com/a?b=c"},
+		{"(http://example.com/x)", "http://example.com/x"},
+		{"no links here", ""},
+		{"ftp://example.com", ""},
+		{"first http://a.example then https://b.example", "http://a.example"},
+	}
+
+	for _, tt := range tests {
+		if got := FirstURL(tt.text); got != tt.want {
+			t.Errorf("FirstURL(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
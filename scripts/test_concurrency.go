@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"sync"
 )
 
@@ -13,11 +14,30 @@ type UpdatePostPayload struct {
 	Content *string `json:"content"`
 }
 
-func updatePost(postID int, p UpdatePostPayload, wg *sync.WaitGroup) {
-	defer wg.Done()
+// getETag fetches the post and returns the ETag of its current version.
+func getETag(url, token string) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
 
-	// Construct the URL for the update endpoint
-	url := fmt.Sprintf("http://localhost:8000/v1/posts/%d", postID)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+func updatePost(url, token, etag string, p UpdatePostPayload, wg *sync.WaitGroup) {
+	defer wg.Done()
 
 	// Create the JSON payload
 	b, _ := json.Marshal(p)
@@ -28,8 +48,10 @@ func updatePost(postID int, p UpdatePostPayload, wg *sync.WaitGroup) {
 		return
 	}
 
-	// Set headers as needed, for example:
+	// Both requests claim to edit the same version, only one can win
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-Match", etag)
 
 	// Send the request
 	client := &http.Client{}
@@ -46,15 +68,26 @@ func updatePost(postID int, p UpdatePostPayload, wg *sync.WaitGroup) {
 func main() {
 	var wg sync.WaitGroup
 
-	// Assuming the post ID to update is 1
+	// Assuming the post ID to update is 3, owned by the user of TOKEN
 	postID := 3
+	token := os.Getenv("TOKEN")
+
+	// Construct the URL for the update endpoint
+	url := fmt.Sprintf("http://localhost:8000/v1/posts/%d", postID)
+
+	etag, err := getETag(url, token)
+	if err != nil {
+		fmt.Println("Error fetching post:", err)
+		return
+	}
 
-	// Simulate User A and User B updating the same post concurrently
+	// Simulate User A and User B updating the same post concurrently,
+	// expect one 200 OK and one 412 Precondition Failed
 	wg.Add(2)
 	content := "NEW CONTENT FROM USER B"
 	title := "NEW TITLE FROM USER A"
 
-	go updatePost(postID, UpdatePostPayload{Title: &title}, &wg)
-	go updatePost(postID, UpdatePostPayload{Content: &content}, &wg)
+	go updatePost(url, token, etag, UpdatePostPayload{Title: &title}, &wg)
+	go updatePost(url, token, etag, UpdatePostPayload{Content: &content}, &wg)
 	wg.Wait()
 }